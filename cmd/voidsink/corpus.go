@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+)
+
+const corpusUsage = `Usage: voidsink corpus compile [-order N] [-o output.chain] input.txt [input2.txt ...]
+
+Builds a Heffalump Markov chain once and stores it in a compact binary file
+that loads much faster than re-tokenizing the raw text on every start.
+`
+
+// runCorpus implements the "voidsink corpus" subcommands and returns the exit code.
+func runCorpus(args []string) int {
+	if len(args) == 0 || args[0] != "compile" {
+		fmt.Fprint(os.Stderr, corpusUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("corpus compile", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, corpusUsage)
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "", "Output path (default: first input with a .chain extension)")
+	order := fs.Int("order", heffalump.DefaultOrder, "Markov chain order (1-4)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(inputs[0], filepath.Ext(inputs[0])) + ".chain"
+	}
+
+	if err := compileCorpus(inputs, *output, *order); err != nil {
+		fmt.Fprintf(os.Stderr, "corpus compile: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func compileCorpus(inputs []string, output string, order int) error {
+	h, err := heffalump.NewFromFiles(inputs, heffalump.Options{Order: order})
+	if err != nil {
+		return err
+	}
+
+	if err := h.Save(output); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Compiled %d file(s) into %s (order %d, %d bytes)\n", len(inputs), output, h.Order(), info.Size())
+	return nil
+}
+
+// corpusSet loads the configured corpora on first use, so corpora that no
+// enabled trap references never take up memory. get can run from a trap
+// resume while a reload reuses the set, so loaded is guarded by mu.
+type corpusSet struct {
+	configs map[string]config.CorpusConfig
+
+	mu     sync.Mutex
+	loaded map[string]*heffalump.Heffalump
+}
+
+func newCorpusSet(configs map[string]config.CorpusConfig) *corpusSet {
+	return &corpusSet{
+		configs: configs,
+		loaded:  make(map[string]*heffalump.Heffalump),
+	}
+}
+
+// reuse takes over the corpora of prev whose definition did not change, so a
+// reload doesn't rebuild chains needlessly.
+func (c *corpusSet) reuse(prev *corpusSet) *corpusSet {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, h := range prev.loaded {
+		if cc, ok := c.configs[name]; ok && cc == prev.configs[name] {
+			c.loaded[name] = h
+		}
+	}
+	return c
+}
+
+// config returns the definition of the named corpus. An empty name means the default corpus.
+func (c *corpusSet) config(name string) config.CorpusConfig {
+	if name == "" {
+		name = config.DefaultCorpus
+	}
+	return c.configs[name]
+}
+
+// get returns the named corpus, loading it if needed. An empty name means the default corpus.
+func (c *corpusSet) get(name string) (*heffalump.Heffalump, error) {
+	if name == "" {
+		name = config.DefaultCorpus
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.loaded[name]; ok {
+		return h, nil
+	}
+
+	cc, ok := c.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("corpus %q is not defined", name)
+	}
+	paths, err := cc.Paths()
+	if err != nil {
+		return nil, err
+	}
+	h, err := heffalump.NewFromFiles(paths, heffalump.Options{Order: cc.Order})
+	if err != nil {
+		return nil, fmt.Errorf("loading corpus %q: %w", name, err)
+	}
+
+	c.loaded[name] = h
+	return h, nil
+}
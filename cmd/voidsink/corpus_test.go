@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+)
+
+// TestCorpusSet_ResumeDuringReload loads corpora as a trap resume does while
+// reloads reuse the same set, which must not race.
+func TestCorpusSet_ResumeDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	configs := make(map[string]config.CorpusConfig)
+	for _, name := range []string{config.DefaultCorpus, "second", "third"} {
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte("the quick brown fox jumps over the lazy dog and runs away"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		configs[name] = config.CorpusConfig{File: path}
+	}
+	current := newCorpusSet(configs)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, name := range []string{"", "second", "third"} {
+			if _, err := current.get(name); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			newCorpusSet(configs).reuse(current)
+		}
+	}()
+	wg.Wait()
+
+	next := newCorpusSet(configs).reuse(current)
+	h, err := current.get("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := next.get("second"); err != nil || got != h {
+		t.Errorf("expected the reloaded set to reuse the loaded corpus, got %p (%v), want %p", got, err, h)
+	}
+}
@@ -6,19 +6,27 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/admin"
 	"github.com/Kartikey2011yadav/voidsink/internal/config"
-	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
 	"github.com/Kartikey2011yadav/voidsink/internal/logger"
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
 	"github.com/Kartikey2011yadav/voidsink/internal/trap"
-	gziptrap "github.com/Kartikey2011yadav/voidsink/internal/traps/gzip"
-	httptrap "github.com/Kartikey2011yadav/voidsink/internal/traps/http"
-	jsontrap "github.com/Kartikey2011yadav/voidsink/internal/traps/json"
-	logintrap "github.com/Kartikey2011yadav/voidsink/internal/traps/login"
-	spidertrap "github.com/Kartikey2011yadav/voidsink/internal/traps/spider"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/gzip"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/http"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/json"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/login"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/router"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/smtp"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/spider"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/ssh"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,6 +34,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "corpus" {
+		os.Exit(runCorpus(os.Args[2:]))
+	}
+
 	configPath := flag.String("c", "configs/config.yaml", "Path to configuration file")
 	flag.Parse()
 
@@ -40,42 +52,97 @@ func main() {
 	log.Info().Msg("VoidSink starting up...")
 
 	// 3. Initialize Core Components
-	heffalumpEngine, err := heffalump.New("assets/corpus.txt")
+	connGovernor, err := governor.New(cfg.Limits)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize Heffalump engine")
+		log.Fatal().Err(err).Msg("Invalid connection limits")
 	}
 
-	alertNotifier := notifier.New(cfg.Notification.WebhookURL)
+	alerts, err := notifier.New(cfg.Notification)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid notification settings")
+	}
+	// Digests report the bytes wasted by trapped clients
+	session.OnEnd(func(r session.Record) {
+		alerts.CountWasted(r.Trap, r.BytesSent)
+	})
+
+	a := &app{
+		configPath: *configPath,
+		cfg:        cfg,
+		corpora:    newCorpusSet(cfg.Corpora),
+		// Shared by every stream so the total egress stays within budget
+		egress:   throttle.NewBudget(cfg.Egress.BytesPerSecond),
+		notifier: alerts,
+		governor: connGovernor,
+		traps:    trap.NewManager(),
+	}
+
+	if cfg.Reload.Grace > 0 {
+		a.traps.RetireGrace = cfg.Reload.Grace
+	}
+
+	if err := setTrustedProxies(cfg); err != nil {
+		log.Fatal().Err(err).Msg("Invalid trusted proxies")
+	}
+
+	events, err := store.Open(cfg.Store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to open the event store")
+	}
+	if events != nil {
+		log.Info().Str("path", cfg.Store.Path).Msg("Recording events to the store")
+		store.Use(events)
+		session.OnEnd(store.RecordSession)
+	}
+	a.store = events
 
 	// 4. Start Metrics Server
+	telemetry.SetMaxFingerprints(cfg.Metrics.MaxFingerprints)
 	if cfg.Metrics.Enabled {
 		go startMetricsServer(cfg.Metrics.Addr)
 	}
+	if cfg.Admin.Enabled {
+		api := admin.New(cfg.Admin.Token, a.traps, a.store)
+		go func() {
+			if err := admin.ListenAndServe(cfg.Admin.Addr, api); err != nil {
+				log.Error().Err(err).Msg("Admin API failed")
+			}
+		}()
+	}
 
 	// 5. Initialize Traps
-	traps := initializeTraps(cfg, heffalumpEngine, alertNotifier)
-	if len(traps) == 0 {
+	specs, err := a.buildSpecs(cfg, a.corpora, a.egress)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize traps")
+	}
+	if len(specs) == 0 {
 		log.Warn().Msg("No traps enabled. Exiting.")
 		return
 	}
 
 	// 6. Start Traps
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	var wg sync.WaitGroup
-	for _, t := range traps {
-		wg.Add(1)
-		go func(tr trap.Trap) {
-			defer wg.Done()
-			if err := tr.Start(ctx); err != nil {
-				log.Error().Err(err).Msg("Trap stopped with error")
-			}
-		}(t)
+	if _, err := a.traps.Apply(specs); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start traps")
+	}
+
+	// 7. Reload on SIGHUP or file changes until interrupted
+	changed := make(chan struct{}, 1)
+	if cfg.Reload.Watch {
+		watchConfig(a.configPath, changed)
 	}
+	a.run(changed)
+}
 
-	// 7. Wait for Interrupt Signal
-	waitForShutdown(cancel, traps, &wg)
+// app holds the state that survives configuration reloads.
+type app struct {
+	configPath string
+	cfg        *config.Config
+	corpora    *corpusSet
+	egress     *throttle.Budget
+	notifier   *notifier.Notifier
+	governor   *governor.Governor
+	traps      *trap.Manager
+	store      *store.Store
 }
 
 func startMetricsServer(addr string) {
@@ -86,64 +153,75 @@ func startMetricsServer(addr string) {
 	}
 }
 
-func initializeTraps(cfg *config.Config, h *heffalump.Heffalump, n *notifier.Notifier) []trap.Trap {
-	var traps []trap.Trap
-
-	if cfg.Traps.HTTPInfinite.Enabled {
-		t := httptrap.New(cfg.Traps.HTTPInfinite.Addr, cfg.Traps.HTTPInfinite.ServerName, h, n)
-		traps = append(traps, t)
-		log.Info().Str("type", "HTTPInfinite").Str("addr", cfg.Traps.HTTPInfinite.Addr).Msg("Trap enabled")
-	}
-
-	if cfg.Traps.JSONInfinite.Enabled {
-		t := jsontrap.New(cfg.Traps.JSONInfinite.Addr, cfg.Traps.JSONInfinite.ServerName, h, n)
-		traps = append(traps, t)
-		log.Info().Str("type", "JSONInfinite").Str("addr", cfg.Traps.JSONInfinite.Addr).Msg("Trap enabled")
+// setTrustedProxies applies the proxies whose forwarding headers are believed.
+func setTrustedProxies(cfg *config.Config) error {
+	prefixes, err := proxy.ParseTrusted(cfg.Proxies.Trusted)
+	if err != nil {
+		return err
 	}
+	proxy.SetTrusted(prefixes)
+	return nil
+}
 
-	if cfg.Traps.SpiderTrap.Enabled {
-		t := spidertrap.New(cfg.Traps.SpiderTrap.Addr, cfg.Traps.SpiderTrap.ServerName, h, n)
-		traps = append(traps, t)
-		log.Info().Str("type", "SpiderTrap").Str("addr", cfg.Traps.SpiderTrap.Addr).Msg("Trap enabled")
+// buildSpecs describes the enabled trap instances of cfg.
+func (a *app) buildSpecs(cfg *config.Config, corpora *corpusSet, egress *throttle.Budget) ([]trap.Spec, error) {
+	deps := trap.Deps{
+		Notifier: a.notifier,
+		Governor: a.governor,
+		Egress:   egress,
+		Corpus:   corpora.get,
 	}
 
-	if cfg.Traps.GzipInfinite.Enabled {
-		t := gziptrap.New(cfg.Traps.GzipInfinite.Addr, cfg.Traps.GzipInfinite.ServerName, n)
-		traps = append(traps, t)
-		log.Info().Str("type", "GzipInfinite").Str("addr", cfg.Traps.GzipInfinite.Addr).Msg("Trap enabled")
+	var specs []trap.Spec
+	for _, c := range cfg.Traps {
+		if !c.Enabled {
+			continue
+		}
+		spec, err := trap.NewSpec(c, deps)
+		if err != nil {
+			return nil, err
+		}
+		// Shared resources are not part of the instance options, but a
+		// change to them must still reach the trap on reload
+		spec.Config = []interface{}{spec.Config, cfg.Egress, corpora.config(c.Corpus())}
+		specs = append(specs, spec)
+		log.Info().Str("name", c.Name).Str("type", c.Type).Str("addr", c.Addr).Msg("Trap enabled")
 	}
+	return specs, nil
+}
 
-	if cfg.Traps.LoginTrap.Enabled {
-		t := logintrap.New(cfg.Traps.LoginTrap.Addr, cfg.Traps.LoginTrap.ServerName, n)
-		traps = append(traps, t)
-		log.Info().Str("type", "LoginTrap").Str("addr", cfg.Traps.LoginTrap.Addr).Msg("Trap enabled")
+// run waits for signals, reloading the configuration on SIGHUP or when
+// changed fires, and shuts down on SIGINT or SIGTERM.
+func (a *app) run(changed <-chan struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				a.reload("signal")
+				continue
+			}
+		case <-changed:
+			a.reload("watch")
+			continue
+		}
+		break
 	}
 
-	return traps
-}
-
-func waitForShutdown(cancel context.CancelFunc, traps []trap.Trap, wg *sync.WaitGroup) {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
 	log.Info().Msg("Shutting down VoidSink...")
 
-	cancel() // Signal traps to stop
-
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 
-	var shutdownWg sync.WaitGroup
-	for _, t := range traps {
-		shutdownWg.Add(1)
-		go func(tr trap.Trap) {
-			defer shutdownWg.Done()
-			if err := tr.Shutdown(shutdownCtx); err != nil {
-				log.Error().Err(err).Msg("Error during trap shutdown")
-			}
-		}(t)
+	if err := a.traps.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("Error during trap shutdown")
+	}
+	// After the traps, so the alerts and sessions they end with are kept
+	a.notifier.Close()
+	if err := a.store.Close(); err != nil {
+		log.Error().Err(err).Msg("Error closing the event store")
 	}
-	shutdownWg.Wait()
-	wg.Wait()
 	log.Info().Msg("VoidSink shutdown complete")
 }
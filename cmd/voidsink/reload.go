@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/rs/zerolog/log"
+)
+
+// watchDebounce gives editors time to finish writing before the file is read.
+const watchDebounce = 500 * time.Millisecond
+
+// reload re-reads the configuration and applies it to the running traps.
+// A broken configuration is logged and the current one stays in effect.
+func (a *app) reload(trigger string) {
+	log.Info().Str("trigger", trigger).Str("path", a.configPath).Msg("Reloading configuration")
+
+	if err := a.apply(); err != nil {
+		telemetry.ConfigReloads.WithLabelValues("failure").Inc()
+		log.Error().Err(err).Msg("Configuration reload failed, keeping the current configuration")
+		return
+	}
+	telemetry.ConfigReloads.WithLabelValues("success").Inc()
+}
+
+func (a *app) apply() error {
+	cfg, err := config.Load(a.configPath)
+	if err != nil {
+		return err
+	}
+
+	corpora := newCorpusSet(cfg.Corpora).reuse(a.corpora)
+	egress := a.egress
+	if cfg.Egress != a.cfg.Egress {
+		egress = throttle.NewBudget(cfg.Egress.BytesPerSecond)
+	}
+
+	specs, err := a.buildSpecs(cfg, corpora, egress)
+	if err != nil {
+		return err
+	}
+	// Validate has parsed the list already, so this only fails before any
+	// trap is touched
+	if err := setTrustedProxies(cfg); err != nil {
+		return err
+	}
+	result, err := a.traps.Apply(specs)
+	if err != nil {
+		// Keep the proxies in line with the traps still running
+		setTrustedProxies(a.cfg)
+		return err
+	}
+	warnRestartRequired(a.cfg, cfg)
+	a.cfg, a.corpora, a.egress = cfg, corpora, egress
+
+	log.Info().
+		Strs("started", result.Started).
+		Strs("stopped", result.Stopped).
+		Strs("restarted", result.Restarted).
+		Strs("reconfigured", result.Reconfigured).
+		Bool("changed", result.Changed()).
+		Msg("Configuration reloaded")
+	return nil
+}
+
+// warnRestartRequired logs the settings that are only read at startup.
+func warnRestartRequired(old, cfg *config.Config) {
+	var keys []string
+	if old.LogLevel != cfg.LogLevel || old.LogFile != cfg.LogFile || old.LogFormat != cfg.LogFormat {
+		keys = append(keys, "logging")
+	}
+	if old.Metrics != cfg.Metrics {
+		keys = append(keys, "metrics")
+	}
+	if old.Admin != cfg.Admin {
+		keys = append(keys, "admin")
+	}
+	if old.Limits != cfg.Limits {
+		keys = append(keys, "limits")
+	}
+	if old.Store != cfg.Store {
+		keys = append(keys, "store")
+	}
+	if !reflect.DeepEqual(old.Notification, cfg.Notification) {
+		keys = append(keys, "notification")
+	}
+	if old.Reload != cfg.Reload {
+		keys = append(keys, "reload")
+	}
+	if len(keys) > 0 {
+		log.Warn().Strs("settings", keys).Msg("Some changed settings only take effect after a restart")
+	}
+}
+
+// watchConfig signals changed whenever the configuration file is written.
+// Bursts of writes are coalesced into a single signal.
+func watchConfig(path string, changed chan<- struct{}) {
+	events := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+
+	var watch func()
+	watch = func() {
+		err := file.Provider(path).Watch(func(_ interface{}, err error) {
+			if err != nil {
+				// Editors often replace the file instead of writing it,
+				// which ends the watch. Pick up the new file and carry on.
+				log.Debug().Err(err).Str("path", path).Msg("Config watch interrupted, restarting")
+				time.AfterFunc(watchDebounce, watch)
+				notify()
+				return
+			}
+			notify()
+		})
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to watch configuration file")
+		}
+	}
+	watch()
+
+	go func() {
+		for range events {
+			time.Sleep(watchDebounce)
+			select {
+			case <-events:
+			default:
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	log.Info().Str("path", path).Msg("Watching configuration file for changes")
+}
@@ -0,0 +1,206 @@
+// Package admin serves an authenticated REST API for inspecting and
+// controlling a running VoidSink: its traps, live connections and the
+// history kept in the event store.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/rs/zerolog/log"
+)
+
+// API serves the admin endpoints.
+type API struct {
+	token []byte
+	traps *trap.Manager
+	store *store.Store
+	mux   *http.ServeMux
+}
+
+// New creates the API. Every request must send token as
+// "Authorization: Bearer <token>". A nil store makes the history endpoints
+// answer 503.
+func New(token string, traps *trap.Manager, s *store.Store) *API {
+	a := &API{token: []byte(token), traps: traps, store: s, mux: http.NewServeMux()}
+
+	a.mux.HandleFunc("GET /api/traps", a.listTraps)
+	a.mux.HandleFunc("POST /api/traps/{name}/pause", a.pauseTrap)
+	a.mux.HandleFunc("POST /api/traps/{name}/resume", a.resumeTrap)
+	a.mux.HandleFunc("GET /api/connections", a.listConnections)
+	a.mux.HandleFunc("DELETE /api/connections/{id}", a.kickConnection)
+	a.mux.HandleFunc("GET /api/hits", a.events(store.KindHit))
+	a.mux.HandleFunc("GET /api/sessions", a.events(store.KindSession))
+	a.mux.HandleFunc("GET /api/credentials", a.events(store.KindCredential))
+	a.mux.HandleFunc("GET /api/top-ips", a.topIPs)
+	return a
+}
+
+// ServeHTTP checks the bearer token before dispatching the request.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || len(a.token) == 0 || subtle.ConstantTimeCompare([]byte(token), a.token) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="voidsink"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until it fails.
+func ListenAndServe(addr string, a *API) error {
+	log.Info().Str("addr", addr).Msg("Starting Admin API")
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           a,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+func (a *API) listTraps(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.traps.Status())
+}
+
+func (a *API) pauseTrap(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.traps.Pause(name); err != nil {
+		writeError(w, trapErrorStatus(err), err)
+		return
+	}
+	log.Info().Str("trap", name).Msg("Trap paused through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) resumeTrap(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.traps.Resume(name); err != nil {
+		writeError(w, trapErrorStatus(err), err)
+		return
+	}
+	log.Info().Str("trap", name).Msg("Trap resumed through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func trapErrorStatus(err error) int {
+	if errors.Is(err, trap.ErrUnknownTrap) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *API) listConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, session.Live())
+}
+
+func (a *API) kickConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid connection id: %w", err))
+		return
+	}
+	if !session.Kick(id) {
+		writeError(w, http.StatusNotFound, errors.New("no such connection"))
+		return
+	}
+	log.Info().Uint64("session", id).Msg("Connection kicked through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// events lists stored events of one kind, filtered by the ip, trap, since,
+// until and limit query parameters.
+func (a *API) events(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.store == nil {
+			writeError(w, http.StatusServiceUnavailable, errStoreDisabled)
+			return
+		}
+		q, err := parseQuery(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		q.Kind = kind
+		events, err := a.store.Query(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if events == nil {
+			events = []store.Event{}
+		}
+		writeJSON(w, http.StatusOK, events)
+	}
+}
+
+func (a *API) topIPs(w http.ResponseWriter, r *http.Request) {
+	if a.store == nil {
+		writeError(w, http.StatusServiceUnavailable, errStoreDisabled)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	top, err := a.store.TopIPs(q.Since, q.Limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, top)
+}
+
+var errStoreDisabled = errors.New("the event store is disabled, set store.path to enable it")
+
+// parseQuery reads the filters shared by the history endpoints. since and
+// until take an RFC 3339 time or a duration back from now, e.g. "1h".
+func parseQuery(r *http.Request) (store.Query, error) {
+	v := r.URL.Query()
+	q := store.Query{IP: v.Get("ip"), Trap: v.Get("trap")}
+
+	var err error
+	if q.Since, err = parseTime(v.Get("since")); err != nil {
+		return q, fmt.Errorf("since: %w", err)
+	}
+	if q.Until, err = parseTime(v.Get("until")); err != nil {
+		return q, fmt.Errorf("until: %w", err)
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("limit: invalid value %q", s)
+		}
+	}
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug().Err(err).Msg("Failed to write admin API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
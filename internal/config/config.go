@@ -1,10 +1,22 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -19,42 +31,79 @@ type Config struct {
 	Metrics   struct {
 		Enabled bool   `koanf:"enabled"`
 		Addr    string `koanf:"addr"`
+		// MaxFingerprints caps the distinct client fingerprints used as label values.
+		MaxFingerprints int `koanf:"max_fingerprints"`
 	} `koanf:"metrics"`
-	Notification struct {
-		WebhookURL string `koanf:"webhook_url"`
-	} `koanf:"notification"`
-	Traps struct {
-		HTTPInfinite struct {
-			Enabled    bool   `koanf:"enabled"`
-			Addr       string `koanf:"addr"`
-			ServerName string `koanf:"server_name"`
-		} `koanf:"http_infinite"`
-		JSONInfinite struct {
-			Enabled    bool   `koanf:"enabled"`
-			Addr       string `koanf:"addr"`
-			ServerName string `koanf:"server_name"`
-		} `koanf:"json_infinite"`
-		SpiderTrap struct {
-			Enabled    bool   `koanf:"enabled"`
-			Addr       string `koanf:"addr"`
-			ServerName string `koanf:"server_name"`
-		} `koanf:"spider_trap"`
-		GzipInfinite struct {
-			Enabled    bool   `koanf:"enabled"`
-			Addr       string `koanf:"addr"`
-			ServerName string `koanf:"server_name"`
-		} `koanf:"gzip_infinite"`
-		LoginTrap struct {
-			Enabled    bool   `koanf:"enabled"`
-			Addr       string `koanf:"addr"`
-			ServerName string `koanf:"server_name"`
-		} `koanf:"login_trap"`
-	} `koanf:"traps"`
+	Admin struct {
+		Enabled bool   `koanf:"enabled"`
+		Addr    string `koanf:"addr"`
+		// Token authenticates every request to the admin API.
+		Token string `koanf:"token"`
+	} `koanf:"admin"`
+	Heffalump struct {
+		Order int `koanf:"order"`
+	} `koanf:"heffalump"`
+	Corpora map[string]CorpusConfig `koanf:"corpora"`
+	Egress  struct {
+		BytesPerSecond int `koanf:"bytes_per_second"`
+	} `koanf:"egress"`
+	Limits  governor.Options `koanf:"limits"`
+	Proxies struct {
+		// Trusted lists the CIDRs of proxies allowed to send PROXY protocol
+		// and X-Forwarded-For headers.
+		Trusted []string `koanf:"trusted"`
+	} `koanf:"proxies"`
+	// Store persists hits, sessions and credentials. Disabled without a path.
+	Store        store.Options    `koanf:"store"`
+	Notification notifier.Options `koanf:"notification"`
+	Reload       struct {
+		// Watch reloads the configuration whenever the file changes.
+		Watch bool `koanf:"watch"`
+		// Grace is how long a stopped or moved trap keeps its clients. Zero
+		// means one hour.
+		Grace time.Duration `koanf:"grace"`
+	} `koanf:"reload"`
+	// Traps is decoded by hand in Load, see decodeTraps.
+	Traps []TrapConfig `koanf:"-"`
+}
+
+// TrapConfig defines a single trap instance. Several instances of the same
+// type can run side by side on different addresses.
+type TrapConfig struct {
+	// Name identifies the instance in logs and across reloads. Defaults to Type.
+	Name string
+	// Type selects the trap implementation, e.g. "http_infinite".
+	Type    string
+	Enabled bool
+	Addr    string
+	// Options holds the remaining keys, decoded by the trap type itself.
+	Options map[string]interface{}
 }
 
-var k = koanf.New(".")
+// Corpus returns the corpus named by the "corpus" option, if any.
+func (t TrapConfig) Corpus() string {
+	name, _ := t.Options["corpus"].(string)
+	return name
+}
+
+// DefaultCorpus is the corpus used by traps that don't name one.
+const DefaultCorpus = "default"
+
+// CorpusConfig defines a named Heffalump corpus. Exactly one source must be set.
+type CorpusConfig struct {
+	// File is a raw text corpus (or a compiled chain, detected automatically).
+	File string `koanf:"file"`
+	// Glob matches several raw text files that are combined into one chain.
+	Glob string `koanf:"glob"`
+	// Compiled is a chain built with "voidsink corpus compile".
+	Compiled string `koanf:"compiled"`
+	// Order overrides heffalump.order for this corpus.
+	Order int `koanf:"order"`
+}
 
 func Load(path string) (*Config, error) {
+	k := koanf.New(".")
+
 	// Determine parser based on extension
 	var parser koanf.Parser
 	ext := strings.ToLower(filepath.Ext(path))
@@ -67,8 +116,12 @@ func Load(path string) (*Config, error) {
 		parser = yaml.Parser()
 	}
 
-	// Load from file
+	// Load from file. A missing file leaves the environment and defaults,
+	// but a broken one is an error so a reload never starts from scratch.
 	if err := k.Load(file.Provider(path), parser); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("loading config file: %w", err)
+		}
 		log.Printf("Error loading config file: %v", err)
 	}
 
@@ -82,6 +135,249 @@ func Load(path string) (*Config, error) {
 	if err := k.Unmarshal("", &cfg); err != nil {
 		return nil, err
 	}
+	traps, err := decodeTraps(k.Get("traps"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Traps = traps
+
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
+
+func (c *Config) applyDefaults() {
+	if len(c.Corpora) == 0 {
+		c.Corpora = map[string]CorpusConfig{
+			DefaultCorpus: {File: "assets/corpus.txt"},
+		}
+	}
+	for name, corpus := range c.Corpora {
+		// Compiled chains carry their own order
+		if corpus.Order == 0 && corpus.Compiled == "" {
+			corpus.Order = c.Heffalump.Order
+			c.Corpora[name] = corpus
+		}
+	}
+}
+
+// Validate checks the configuration for mistakes that would otherwise only
+// show up once a trap starts.
+func (c *Config) Validate() error {
+	for name, corpus := range c.Corpora {
+		if err := corpus.validate(); err != nil {
+			return fmt.Errorf("corpora.%s: %w", name, err)
+		}
+	}
+
+	if c.Admin.Enabled && c.Admin.Token == "" {
+		return errors.New("admin.token: a token is required to enable the admin API")
+	}
+
+	if err := c.Notification.Validate(); err != nil {
+		return fmt.Errorf("notification.%w", err)
+	}
+
+	if _, err := proxy.ParseTrusted(c.Proxies.Trusted); err != nil {
+		return fmt.Errorf("proxies.trusted: %w", err)
+	}
+
+	names := make(map[string]bool, len(c.Traps))
+	for _, t := range c.Traps {
+		if names[t.Name] {
+			return fmt.Errorf("traps.%s: name is used by several traps, give each instance its own name", t.Name)
+		}
+		names[t.Name] = true
+
+		if !t.Enabled {
+			continue
+		}
+		// Without trusted proxies no header would be believed
+		if optionBool(t.Options["proxy_protocol"]) && len(c.Proxies.Trusted) == 0 {
+			return fmt.Errorf("traps.%s: proxy_protocol requires proxies.trusted to list the proxies allowed to send the header", t.Name)
+		}
+		if err := c.checkCorpus(t.Type, t.Options); err != nil {
+			return fmt.Errorf("traps.%s: %w", t.Name, err)
+		}
+		// The routes of a router build their own traps
+		routes, _ := t.Options["routes"].([]interface{})
+		for i, r := range routes {
+			route, _ := r.(map[string]interface{})
+			typ, _ := route["trap"].(string)
+			options, _ := route["options"].(map[string]interface{})
+			if err := c.checkCorpus(typ, options); err != nil {
+				return fmt.Errorf("traps.%s: routes[%d]: %w", t.Name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// optionBool reads a boolean trap option, which is a string when set from
+// the environment.
+func optionBool(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// usesCorpus reports whether a trap type streams a Heffalump corpus. The
+// trap registry sets it, as this package can't import it.
+var usesCorpus = func(typ string) bool { return false }
+
+// SetCorpusTypes sets how Validate tells the trap types that use a corpus.
+func SetCorpusTypes(fn func(typ string) bool) {
+	usesCorpus = fn
+}
+
+// checkCorpus checks that the corpus used by a trap of type typ is defined.
+// Corpus traps without a corpus option use DefaultCorpus; other traps
+// ignore the option, so it is only checked when set.
+func (c *Config) checkCorpus(typ string, options map[string]interface{}) error {
+	name, ok := options["corpus"]
+	if !ok && !usesCorpus(typ) {
+		return nil
+	}
+	corpus, _ := name.(string)
+	if corpus == "" {
+		corpus = DefaultCorpus
+	}
+	if _, ok := c.Corpora[corpus]; !ok {
+		return fmt.Errorf("corpus %q is not defined in corpora", corpus)
+	}
+	return nil
+}
+
+// decodeTraps reads the trap instances. The list form is preferred:
+//
+//	traps:
+//	  - type: http_infinite
+//	    addr: ":8080"
+//
+// The older map form, keyed by trap type, is still accepted. There an
+// instance is disabled unless it sets enabled: true, as before.
+func decodeTraps(raw interface{}) ([]TrapConfig, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		traps := make([]TrapConfig, 0, len(v))
+		for i, item := range v {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("traps[%d]: expected a map, got %T", i, item)
+			}
+			t, err := decodeTrap(m, "", true)
+			if err != nil {
+				return nil, fmt.Errorf("traps[%d]: %w", i, err)
+			}
+			traps = append(traps, t)
+		}
+		return traps, nil
+	case map[string]interface{}:
+		types := make([]string, 0, len(v))
+		for typ := range v {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+
+		traps := make([]TrapConfig, 0, len(v))
+		for _, typ := range types {
+			m, ok := v[typ].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("traps.%s: expected a map, got %T", typ, v[typ])
+			}
+			t, err := decodeTrap(m, typ, false)
+			if err != nil {
+				return nil, fmt.Errorf("traps.%s: %w", typ, err)
+			}
+			traps = append(traps, t)
+		}
+		return traps, nil
+	default:
+		return nil, fmt.Errorf("traps: expected a list, got %T", raw)
+	}
+}
+
+func decodeTrap(m map[string]interface{}, typ string, enabled bool) (TrapConfig, error) {
+	t := TrapConfig{Type: typ, Enabled: enabled, Options: make(map[string]interface{})}
+	for key, value := range m {
+		var ok bool
+		switch key {
+		case "name":
+			t.Name, ok = value.(string)
+		case "type":
+			t.Type, ok = value.(string)
+		case "addr":
+			t.Addr, ok = value.(string)
+		case "enabled":
+			t.Enabled, ok = value.(bool)
+		default:
+			t.Options[key], ok = value, true
+		}
+		if !ok {
+			return t, fmt.Errorf("%s: unexpected value %v", key, value)
+		}
+	}
+	if t.Type == "" {
+		return t, errors.New("type is required")
+	}
+	if t.Name == "" {
+		t.Name = t.Type
+	}
+	return t, nil
+}
+
+// Paths returns the files making up the corpus.
+func (c CorpusConfig) Paths() ([]string, error) {
+	switch {
+	case c.File != "":
+		return []string{c.File}, nil
+	case c.Compiled != "":
+		return []string{c.Compiled}, nil
+	default:
+		paths, err := filepath.Glob(c.Glob)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+		return paths, nil
+	}
+}
+
+func (c CorpusConfig) validate() error {
+	sources := 0
+	for _, src := range []string{c.File, c.Glob, c.Compiled} {
+		if src != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("exactly one of file, glob or compiled must be set")
+	}
+	if c.Order < 0 || c.Order > heffalump.MaxOrder {
+		return fmt.Errorf("order must be between 1 and %d, got %d", heffalump.MaxOrder, c.Order)
+	}
+
+	paths, err := c.Paths()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("glob %q matches no files", c.Glob)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
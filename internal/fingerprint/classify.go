@@ -0,0 +1,84 @@
+package fingerprint
+
+import "strings"
+
+// Client classes assigned by Classify.
+const (
+	ClassAICrawler    = "ai_crawler"
+	ClassSearchEngine = "search_engine"
+	ClassScanner      = "scanner"
+	ClassScript       = "script"
+	ClassBrowser      = "browser"
+	// ClassFakeBrowser claims to be a browser but lacks the headers every
+	// browser sends.
+	ClassFakeBrowser = "fake_browser"
+	ClassUnknown     = "unknown"
+)
+
+// Rule assigns Class to clients whose User-Agent contains one of UserAgents,
+// compared case-insensitively.
+type Rule struct {
+	Class      string
+	UserAgents []string
+}
+
+// Rules are tried in order by Classify. Crawlers come first since many of
+// them also claim to be Mozilla.
+var Rules = []Rule{
+	{ClassAICrawler, []string{
+		"gptbot", "chatgpt-user", "oai-searchbot", "claudebot", "claude-web", "claude-user", "anthropic-ai",
+		"ccbot", "perplexitybot", "perplexity-user", "bytespider", "google-extended", "amazonbot",
+		"applebot-extended", "meta-externalagent", "meta-externalfetcher", "facebookbot", "cohere-ai",
+		"diffbot", "youbot", "ai2bot", "omgili", "timpibot", "imagesiftbot",
+	}},
+	{ClassSearchEngine, []string{
+		"googlebot", "bingbot", "yandexbot", "baiduspider", "duckduckbot", "applebot", "slurp",
+		"seznambot", "sogou", "naver", "qwantify", "mojeekbot", "exabot", "petalbot",
+	}},
+	{ClassScanner, []string{
+		"nuclei", "zgrab", "masscan", "nmap", "sqlmap", "nikto", "wpscan", "censysinspect", "expanse",
+		"nessus", "openvas", "acunetix", "netsparker", "burp", "dirbuster", "gobuster", "ffuf",
+		"feroxbuster", "wfuzz", "l9explore", "l9tcpid", "internetmeasurement", "shodan", "zmap",
+		"leakix", "jaeles", "xray", "whatweb", "httpx - open-source",
+	}},
+	{ClassScript, []string{
+		"curl/", "wget/", "python-requests", "python-urllib", "python-httpx", "aiohttp", "go-http-client",
+		"okhttp", "java/", "apache-httpclient", "libwww-perl", "php/", "guzzlehttp", "axios/", "node-fetch",
+		"undici", "ruby", "httpie", "powershell", "scrapy",
+	}},
+}
+
+// Classify labels the client of a request from its User-Agent and, for
+// clients claiming to be browsers, the headers browsers always send.
+func Classify(headers []Header) string {
+	var ua, lang, encoding string
+	for _, h := range headers {
+		switch strings.ToLower(h.Name) {
+		case "user-agent":
+			ua = strings.ToLower(h.Value)
+		case "accept-language":
+			lang = h.Value
+		case "accept-encoding":
+			encoding = strings.ToLower(h.Value)
+		}
+	}
+	if ua == "" {
+		return ClassUnknown
+	}
+
+	for _, rule := range Rules {
+		for _, s := range rule.UserAgents {
+			if strings.Contains(ua, s) {
+				return rule.Class
+			}
+		}
+	}
+
+	if strings.HasPrefix(ua, "mozilla/5.0") {
+		if lang == "" || !strings.Contains(encoding, "gzip") {
+			return ClassFakeBrowser
+		}
+		return ClassBrowser
+	}
+	return ClassUnknown
+}
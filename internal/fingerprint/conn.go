@@ -0,0 +1,78 @@
+package fingerprint
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// Listener wraps ln so every accepted connection records its ClientHello.
+// Put it below the TLS listener. onHello, if not nil, is called once per
+// connection with its fingerprint.
+func Listener(ln net.Listener, onHello func(*TLS)) net.Listener {
+	return &listener{Listener: ln, onHello: onHello}
+}
+
+type listener struct {
+	net.Listener
+	onHello func(*TLS)
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, onHello: l.onHello, recording: true}, nil
+}
+
+// Conn buffers the first bytes read from a connection until they hold a
+// ClientHello, then stops recording.
+type Conn struct {
+	net.Conn
+	onHello func(*TLS)
+
+	// Only touched by the goroutine reading the connection
+	recording bool
+	buf       []byte
+
+	tls atomic.Pointer[TLS]
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.recording && n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) record(p []byte) {
+	c.buf = append(c.buf, p...)
+	hello, err := ParseClientHello(c.buf)
+	if errors.Is(err, ErrIncomplete) && len(c.buf) < maxClientHello {
+		return
+	}
+	c.recording = false
+	c.buf = nil
+	if err != nil {
+		return
+	}
+
+	fp := hello.Fingerprint()
+	c.tls.Store(fp)
+	if c.onHello != nil {
+		c.onHello(fp)
+	}
+}
+
+// TLS returns the fingerprint of the client, or nil if no ClientHello has
+// been parsed.
+func (c *Conn) TLS() *TLS {
+	return c.tls.Load()
+}
+
+// NetConn returns the wrapped connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
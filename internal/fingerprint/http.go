@@ -0,0 +1,146 @@
+package fingerprint
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Header is a request header as received.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// ParseHeaders splits raw header lines ("Name: value\r\n") into headers,
+// keeping their order.
+func ParseHeaders(raw []byte) []Header {
+	var headers []Header
+	for len(raw) > 0 {
+		line := raw
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			line, raw = raw[:i], raw[i+1:]
+		} else {
+			raw = nil
+		}
+		line = bytes.TrimRight(line, "\r")
+		name, value, ok := bytes.Cut(line, []byte{':'})
+		if !ok || len(name) == 0 {
+			continue
+		}
+		headers = append(headers, Header{Name: string(name), Value: string(bytes.TrimSpace(value))})
+	}
+	return headers
+}
+
+// HTTP is the fingerprint of an HTTP client and the kind of client it appears to be.
+type HTTP struct {
+	// JA4H covers the method, HTTP version, cookie and referer presence, the
+	// header names in order, the language, the cookie names and values, and
+	// the accepted encodings.
+	JA4H string
+	// Class is the result of Classify, e.g. ClassScanner.
+	Class string
+}
+
+// MarshalZerologObject adds the fingerprint to a log event. A nil HTTP adds nothing.
+func (h *HTTP) MarshalZerologObject(e *zerolog.Event) {
+	if h == nil {
+		return
+	}
+	e.Str("ja4h", h.JA4H).Str("client_class", h.Class)
+}
+
+// NewHTTP fingerprints and classifies a request. proto is the protocol of
+// the request line, e.g. "HTTP/1.1".
+func NewHTTP(method, proto string, headers []Header) *HTTP {
+	return &HTTP{JA4H: ja4h(method, proto, headers), Class: Classify(headers)}
+}
+
+// ja4h builds the JA4H fingerprint of a request. On top of the four
+// sections of the JA4H spec, a fifth one hashes the accepted encodings, so
+// clients sending the same headers with different encodings are told apart.
+func ja4h(method, proto string, headers []Header) string {
+	var names, cookieNames, cookies, encodings []string
+	cookie, referer := "n", "n"
+	lang := "0000"
+	for _, h := range headers {
+		switch {
+		case strings.EqualFold(h.Name, "Cookie"):
+			cookie = "c"
+			for _, c := range strings.Split(h.Value, ";") {
+				c = strings.TrimSpace(c)
+				if c == "" {
+					continue
+				}
+				name, _, _ := strings.Cut(c, "=")
+				cookieNames = append(cookieNames, name)
+				cookies = append(cookies, c)
+			}
+			continue
+		case strings.EqualFold(h.Name, "Referer"):
+			referer = "r"
+			continue
+		case strings.EqualFold(h.Name, "Accept-Language"):
+			lang = ja4hLanguage(h.Value)
+		case strings.EqualFold(h.Name, "Accept-Encoding"):
+			encodings = append(encodings, ja4hEncodings(h.Value)...)
+		}
+		names = append(names, h.Name)
+	}
+
+	m := strings.ToLower(method)
+	if len(m) < 2 {
+		m += "00"
+	}
+	a := fmt.Sprintf("%s%s%s%s%02d%s", m[:2], ja4hVersion(proto), cookie, referer, min(len(names), 99), lang)
+
+	sort.Strings(cookieNames)
+	sort.Strings(cookies)
+	return a + "_" + truncatedHash(strings.Join(names, ",")) + "_" +
+		truncatedHash(strings.Join(cookieNames, ",")) + "_" + truncatedHash(strings.Join(cookies, ",")) + "_" +
+		truncatedHash(strings.Join(encodings, ","))
+}
+
+// ja4hEncodings returns the encodings of an Accept-Encoding value in the
+// order sent, lower-cased and without their weights, e.g. "gzip", "br" for
+// "gzip, BR;q=0.9".
+func ja4hEncodings(v string) []string {
+	var encodings []string
+	for _, e := range strings.Split(v, ",") {
+		e, _, _ = strings.Cut(e, ";")
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			encodings = append(encodings, e)
+		}
+	}
+	return encodings
+}
+
+func ja4hVersion(proto string) string {
+	switch strings.ToUpper(proto) {
+	case "HTTP/1.0":
+		return "10"
+	case "HTTP/1.1":
+		return "11"
+	case "HTTP/2", "HTTP/2.0":
+		return "20"
+	case "HTTP/3", "HTTP/3.0":
+		return "30"
+	}
+	return "00"
+}
+
+// ja4hLanguage returns the first four letters of the primary language,
+// e.g. "enus" for "en-US,en;q=0.9".
+func ja4hLanguage(v string) string {
+	lang, _, _ := strings.Cut(v, ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	lang = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "-", ""))
+	if len(lang) > 4 {
+		lang = lang[:4]
+	}
+	return lang + strings.Repeat("0", 4-len(lang))
+}
@@ -0,0 +1,399 @@
+// Package fingerprint identifies clients by how they talk rather than where
+// they come from, so one scanner can be followed across many IPs.
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// maxClientHello caps the bytes buffered while waiting for a ClientHello.
+// Real ones stay well below it, even with post-quantum key shares.
+const maxClientHello = 64 * 1024
+
+// TLS extension numbers used by the fingerprints.
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var (
+	// ErrIncomplete means more bytes are needed to parse the ClientHello.
+	ErrIncomplete = errors.New("fingerprint: incomplete ClientHello")
+	// ErrNotClientHello means the bytes are not a TLS ClientHello.
+	ErrNotClientHello = errors.New("fingerprint: not a TLS ClientHello")
+)
+
+// ClientHello holds the fields of a TLS ClientHello used by JA3 and JA4,
+// in the order the client sent them.
+type ClientHello struct {
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+// TLS is the fingerprint of a TLS client.
+type TLS struct {
+	// JA3 is the MD5 of JA3Full, the form most threat feeds use.
+	JA3     string
+	JA3Full string
+	JA4     string
+}
+
+// MarshalZerologObject adds the fingerprints to a log event. A nil TLS adds nothing.
+func (t *TLS) MarshalZerologObject(e *zerolog.Event) {
+	if t == nil {
+		return
+	}
+	e.Str("ja3", t.JA3).Str("ja4", t.JA4)
+}
+
+// Fingerprint computes the JA3 and JA4 fingerprints of h.
+func (h *ClientHello) Fingerprint() *TLS {
+	full := h.ja3()
+	sum := md5.Sum([]byte(full))
+	return &TLS{JA3: hex.EncodeToString(sum[:]), JA3Full: full, JA4: h.ja4()}
+}
+
+// ParseClientHello parses the TLS records at the start of a connection. It
+// returns ErrIncomplete until data holds the whole ClientHello.
+func ParseClientHello(data []byte) (*ClientHello, error) {
+	// Reassemble the handshake message, which may span several records
+	var msg []byte
+	for {
+		if len(data) < 5 {
+			return nil, ErrIncomplete
+		}
+		if data[0] != 0x16 || data[1] != 0x03 {
+			return nil, ErrNotClientHello
+		}
+		n := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+n {
+			return nil, ErrIncomplete
+		}
+		msg = append(msg, data[5:5+n]...)
+		data = data[5+n:]
+
+		if len(msg) >= 4 {
+			if msg[0] != 0x01 {
+				return nil, ErrNotClientHello
+			}
+			size := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if size > maxClientHello {
+				return nil, ErrNotClientHello
+			}
+			if len(msg) >= 4+size {
+				return parseHello(msg[4 : 4+size])
+			}
+		}
+	}
+}
+
+func parseHello(b []byte) (*ClientHello, error) {
+	r := reader(b)
+	h := &ClientHello{}
+
+	var ok bool
+	if h.Version, ok = r.u16(); !ok {
+		return nil, ErrNotClientHello
+	}
+	// Random and session ID
+	if !r.skip(32) || !r.skip8() {
+		return nil, ErrNotClientHello
+	}
+	suites, ok := r.vec16()
+	if !ok || len(suites)%2 != 0 {
+		return nil, ErrNotClientHello
+	}
+	h.CipherSuites = u16s(suites)
+	// Compression methods
+	if !r.skip8() {
+		return nil, ErrNotClientHello
+	}
+	if len(r) == 0 {
+		// No extensions, as sent by very old clients
+		return h, nil
+	}
+
+	exts, ok := r.vec16()
+	if !ok {
+		return nil, ErrNotClientHello
+	}
+	for er := reader(exts); len(er) > 0; {
+		typ, ok := er.u16()
+		if !ok {
+			return nil, ErrNotClientHello
+		}
+		body, ok := er.vec16()
+		if !ok {
+			return nil, ErrNotClientHello
+		}
+		h.Extensions = append(h.Extensions, typ)
+		h.parseExtension(typ, body)
+	}
+	return h, nil
+}
+
+// parseExtension extracts the fields of the extensions used by the
+// fingerprints. Malformed extension bodies are ignored.
+func (h *ClientHello) parseExtension(typ uint16, body []byte) {
+	r := reader(body)
+	switch typ {
+	case extServerName:
+		list, _ := r.vec16()
+		for lr := reader(list); len(lr) > 0; {
+			nameType, ok := lr.u8()
+			if !ok {
+				return
+			}
+			name, ok := lr.vec16()
+			if !ok {
+				return
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+				return
+			}
+		}
+	case extSupportedGroups:
+		list, _ := r.vec16()
+		h.SupportedGroups = u16s(list)
+	case extECPointFormats:
+		list, _ := r.vec8()
+		h.ECPointFormats = list
+	case extSignatureAlgorithms:
+		list, _ := r.vec16()
+		h.SignatureAlgorithms = u16s(list)
+	case extALPN:
+		list, _ := r.vec16()
+		for lr := reader(list); len(lr) > 0; {
+			proto, ok := lr.vec8()
+			if !ok {
+				return
+			}
+			h.ALPN = append(h.ALPN, string(proto))
+		}
+	case extSupportedVersions:
+		list, _ := r.vec8()
+		h.SupportedVersions = u16s(list)
+	}
+}
+
+// ja3 builds the JA3 string: version, ciphers, extensions, groups and point
+// formats, with GREASE values left out.
+func (h *ClientHello) ja3() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(h.Version)))
+	for _, list := range [][]uint16{h.CipherSuites, h.Extensions, h.SupportedGroups} {
+		b.WriteByte(',')
+		writeDecimals(&b, withoutGREASE(list))
+	}
+	b.WriteByte(',')
+	points := make([]uint16, len(h.ECPointFormats))
+	for i, p := range h.ECPointFormats {
+		points[i] = uint16(p)
+	}
+	writeDecimals(&b, points)
+	return b.String()
+}
+
+// ja4 builds the JA4 fingerprint, which sorts ciphers and extensions so
+// clients that shuffle their extensions keep one fingerprint.
+func (h *ClientHello) ja4() string {
+	ciphers := withoutGREASE(h.CipherSuites)
+	exts := withoutGREASE(h.Extensions)
+
+	sni := "i"
+	if slices.Contains(exts, extServerName) {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h), sni, min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(h.ALPN))
+
+	// The SNI and ALPN extensions are already part of a
+	var hashed []uint16
+	for _, e := range exts {
+		if e != extServerName && e != extALPN {
+			hashed = append(hashed, e)
+		}
+	}
+	c := hexList(sorted(hashed))
+	if len(h.SignatureAlgorithms) > 0 {
+		c += "_" + hexList(withoutGREASE(h.SignatureAlgorithms))
+	}
+	if len(hashed) == 0 {
+		c = ""
+	}
+	return a + "_" + truncatedHash(hexList(sorted(ciphers))) + "_" + truncatedHash(c)
+}
+
+func ja4Version(h *ClientHello) string {
+	v := h.Version
+	if versions := withoutGREASE(h.SupportedVersions); len(versions) > 0 {
+		v = slices.Max(versions)
+	}
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last character of the first ALPN value.
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+	p := alpn[0]
+	first, last := p[0], p[len(p)-1]
+	if !isAlnum(first) || !isAlnum(last) {
+		h := hex.EncodeToString([]byte(p))
+		return h[:1] + h[len(h)-1:]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// truncatedHash returns the first 12 hex digits of the SHA-256 of s, or
+// zeros for an empty s.
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// isGREASE reports whether v is one of the reserved values clients send to
+// keep servers tolerant (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(list []uint16) []uint16 {
+	out := make([]uint16, 0, len(list))
+	for _, v := range list {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func sorted(list []uint16) []uint16 {
+	list = slices.Clone(list)
+	slices.Sort(list)
+	return list
+}
+
+func writeDecimals(b *strings.Builder, list []uint16) {
+	for i, v := range list {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+}
+
+func hexList(list []uint16) string {
+	parts := make([]string, len(list))
+	for i, v := range list {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func u16s(b []byte) []uint16 {
+	list := make([]uint16, len(b)/2)
+	for i := range list {
+		list[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return list
+}
+
+// reader reads the length-prefixed fields of TLS messages.
+type reader []byte
+
+func (r *reader) u8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *reader) skip(n int) bool {
+	_, ok := r.bytes(n)
+	return ok
+}
+
+func (r *reader) vec8() ([]byte, bool) {
+	n, ok := r.u8()
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(n))
+}
+
+func (r *reader) vec16() ([]byte, bool) {
+	n, ok := r.u16()
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(n))
+}
+
+func (r *reader) skip8() bool {
+	_, ok := r.vec8()
+	return ok
+}
@@ -0,0 +1,335 @@
+package governor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog/log"
+)
+
+// Overflow actions applied to connections that exceed a limit.
+const (
+	// OverflowReject closes the connection immediately.
+	OverflowReject = "reject"
+	// OverflowStatic sends the trap's static response and closes the connection.
+	OverflowStatic = "static"
+	// OverflowHold keeps the connection open without ever writing to it.
+	OverflowHold = "hold"
+)
+
+const (
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 64
+	// defaultMaxHeld caps held connections when neither max_held nor
+	// max_total is set.
+	defaultMaxHeld     = 1000
+	staticWriteTimeout = 5 * time.Second
+)
+
+// Options configures the connection limits. A zero limit disables that check.
+type Options struct {
+	MaxTotal     int    `koanf:"max_total"`
+	MaxPerIP     int    `koanf:"max_per_ip"`
+	MaxPerPrefix int    `koanf:"max_per_prefix"`
+	IPv4Prefix   int    `koanf:"ipv4_prefix"`
+	IPv6Prefix   int    `koanf:"ipv6_prefix"`
+	Overflow     string `koanf:"overflow"`
+	// MaxHeld caps the connections kept open by the "hold" action, across
+	// all traps. Beyond it they are rejected. Zero means MaxTotal, or 1000
+	// without one.
+	MaxHeld int `koanf:"max_held"`
+}
+
+// Governor caps the number of simultaneous connections held by all traps,
+// globally, per source IP and per network prefix (/24 for IPv4, /64 for IPv6).
+// A nil Governor imposes no limits.
+type Governor struct {
+	opts Options
+
+	mu        sync.Mutex
+	total     int
+	held      int
+	perIP     map[netip.Addr]int
+	perPrefix map[netip.Prefix]int
+}
+
+// New creates a Governor. It returns nil if no limit is configured.
+func New(opts Options) (*Governor, error) {
+	if opts.MaxTotal <= 0 && opts.MaxPerIP <= 0 && opts.MaxPerPrefix <= 0 {
+		return nil, nil
+	}
+	if opts.IPv4Prefix <= 0 {
+		opts.IPv4Prefix = defaultIPv4Prefix
+	}
+	if opts.IPv6Prefix <= 0 {
+		opts.IPv6Prefix = defaultIPv6Prefix
+	}
+	if opts.IPv4Prefix > 32 || opts.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("governor: invalid prefix length /%d or /%d", opts.IPv4Prefix, opts.IPv6Prefix)
+	}
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OverflowReject
+	case OverflowReject, OverflowStatic, OverflowHold:
+	default:
+		return nil, fmt.Errorf("governor: unknown overflow action %q", opts.Overflow)
+	}
+	if opts.MaxHeld <= 0 {
+		opts.MaxHeld = opts.MaxTotal
+		if opts.MaxHeld <= 0 {
+			opts.MaxHeld = defaultMaxHeld
+		}
+	}
+
+	return &Governor{
+		opts:      opts,
+		perIP:     make(map[netip.Addr]int),
+		perPrefix: make(map[netip.Prefix]int),
+	}, nil
+}
+
+// Acquire reserves a slot for a connection from addr. If a limit is hit it
+// returns the name of that limit and false. Otherwise the returned release
+// function must be called exactly once when the connection ends.
+func (g *Governor) Acquire(addr net.Addr) (release func(), limit string, ok bool) {
+	if g == nil {
+		return func() {}, "", true
+	}
+
+	ip := addrIP(addr)
+	prefix := g.prefix(ip)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case g.opts.MaxTotal > 0 && g.total >= g.opts.MaxTotal:
+		return nil, "total", false
+	case g.opts.MaxPerIP > 0 && g.perIP[ip] >= g.opts.MaxPerIP:
+		return nil, "ip", false
+	case g.opts.MaxPerPrefix > 0 && g.perPrefix[prefix] >= g.opts.MaxPerPrefix:
+		return nil, "prefix", false
+	}
+
+	g.total++
+	g.perIP[ip]++
+	g.perPrefix[prefix]++
+	g.updateGauges()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { g.release(ip, prefix) })
+	}, "", true
+}
+
+func (g *Governor) release(ip netip.Addr, prefix netip.Prefix) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.total--
+	if g.perIP[ip]--; g.perIP[ip] <= 0 {
+		delete(g.perIP, ip)
+	}
+	if g.perPrefix[prefix]--; g.perPrefix[prefix] <= 0 {
+		delete(g.perPrefix, prefix)
+	}
+	g.updateGauges()
+}
+
+// acquireHeld reserves a slot for a held connection. It returns false once
+// MaxHeld connections are held.
+func (g *Governor) acquireHeld() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.held >= g.opts.MaxHeld {
+		return false
+	}
+	g.held++
+	return true
+}
+
+func (g *Governor) releaseHeld() {
+	g.mu.Lock()
+	g.held--
+	g.mu.Unlock()
+}
+
+// updateGauges must be called with g.mu held.
+func (g *Governor) updateGauges() {
+	telemetry.GovernorConnections.Set(float64(g.total))
+	telemetry.GovernorTrackedIPs.Set(float64(len(g.perIP)))
+	telemetry.GovernorTrackedPrefixes.Set(float64(len(g.perPrefix)))
+}
+
+func (g *Governor) prefix(ip netip.Addr) netip.Prefix {
+	bits := g.opts.IPv6Prefix
+	if ip.Is4() {
+		bits = g.opts.IPv4Prefix
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}
+	}
+	return p
+}
+
+// addrIP extracts the IP address of a connection's remote address.
+func addrIP(addr net.Addr) netip.Addr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, _ := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// Listener wraps ln so that every accepted connection is counted against the
+// governor. Connections over a limit never reach the trap; they are handled
+// according to the overflow action. static is the response written by the
+// "static" action; if it is nil those connections are rejected instead.
+func (g *Governor) Listener(ln net.Listener, trapType string, static []byte) net.Listener {
+	if g == nil {
+		return ln
+	}
+	return &listener{
+		Listener: ln,
+		gov:      g,
+		trapType: trapType,
+		static:   static,
+		held:     make(map[net.Conn]struct{}),
+	}
+}
+
+type listener struct {
+	net.Listener
+	gov      *Governor
+	trapType string
+	static   []byte
+
+	mu     sync.Mutex
+	held   map[net.Conn]struct{}
+	closed bool
+}
+
+// Accept returns the next connection that is within the limits.
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		release, limit, ok := l.gov.Acquire(conn.RemoteAddr())
+		if ok {
+			return &trackedConn{Conn: conn, release: release}, nil
+		}
+		l.overflow(conn, limit)
+	}
+}
+
+// Close stops the listener and drops any connections held by the "hold" action.
+func (l *listener) Close() error {
+	err := l.Listener.Close()
+
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.held {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	return err
+}
+
+func (l *listener) overflow(conn net.Conn, limit string) {
+	action := l.gov.opts.Overflow
+	if action == OverflowStatic && l.static == nil {
+		action = OverflowReject
+	}
+	// Held connections cost a descriptor and a goroutine each, so they are capped too
+	if action == OverflowHold && !l.gov.acquireHeld() {
+		action = OverflowReject
+	}
+
+	telemetry.GovernorOverflows.WithLabelValues(l.trapType, limit, action).Inc()
+	log.Debug().
+		Str("trap", l.trapType).
+		Str("remote_addr", conn.RemoteAddr().String()).
+		Str("limit", limit).
+		Str("action", action).
+		Msg("Connection limit reached")
+
+	switch action {
+	case OverflowStatic:
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(staticWriteTimeout))
+			conn.Write(l.static)
+		}()
+	case OverflowHold:
+		if !l.hold(conn) {
+			l.gov.releaseHeld()
+			conn.Close()
+			return
+		}
+		go func() {
+			telemetry.GovernorHeldConnections.Inc()
+			defer telemetry.GovernorHeldConnections.Dec()
+			defer l.gov.releaseHeld()
+
+			// Swallow whatever the client sends until it gives up
+			io.Copy(io.Discard, conn)
+			conn.Close()
+
+			l.mu.Lock()
+			delete(l.held, conn)
+			l.mu.Unlock()
+		}()
+	default:
+		conn.Close()
+	}
+}
+
+func (l *listener) hold(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.held[conn] = struct{}{}
+	return true
+}
+
+// trackedConn releases its governor slot when closed.
+type trackedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.release()
+	err := c.Conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// HTTPStaticResponse builds a minimal "503 Service Unavailable" response for
+// HTTP traps, carrying the trap's Server header persona.
+func HTTPStaticResponse(serverName string) []byte {
+	body := "<html>\r\n<head><title>503 Service Temporarily Unavailable</title></head>\r\n" +
+		"<body>\r\n<center><h1>503 Service Temporarily Unavailable</h1></center>\r\n</body>\r\n</html>\r\n"
+	return []byte(fmt.Sprintf("HTTP/1.1 503 Service Temporarily Unavailable\r\n"+
+		"Server: %s\r\nContent-Type: text/html\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		serverName, len(body), body))
+}
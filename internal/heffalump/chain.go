@@ -0,0 +1,88 @@
+package heffalump
+
+import (
+	"bufio"
+	"io"
+	"slices"
+)
+
+// build tokenizes r with ScanHTML and builds a chain of the given order.
+func build(r io.Reader, order int) (*Heffalump, error) {
+	h := &Heffalump{
+		order: order,
+		ids:   make(map[string]uint32),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(ScanHTML)
+
+	// Intern every token and remember the corpus as a sequence of IDs
+	var seq []uint32
+	for scanner.Scan() {
+		b := scanner.Bytes()
+		id, ok := h.ids[string(b)]
+		if !ok {
+			id = uint32(len(h.tokens))
+			token := string(b)
+			h.tokens = append(h.tokens, token)
+			h.ids[token] = id
+		}
+		seq = append(seq, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(seq) <= order {
+		h.offsets = []uint32{0}
+		h.first = make([]uint32, len(h.tokens)+1)
+		return h, nil
+	}
+
+	// Sort the positions of every (order+1)-gram. Identical states then
+	// sit next to each other, followed by their successors in order.
+	pos := make([]uint32, len(seq)-order)
+	for i := range pos {
+		pos[i] = uint32(i)
+	}
+	slices.SortFunc(pos, func(a, b uint32) int {
+		return slices.Compare(seq[a:a+uint32(order)+1], seq[b:b+uint32(order)+1])
+	})
+
+	// Collapse runs into states, distinct successors and cumulative weights
+	var prev []uint32
+	for _, p := range pos {
+		gram := seq[p : p+uint32(order)+1]
+		switch {
+		case prev != nil && slices.Equal(gram, prev):
+			// Same transition again, bump its weight
+			h.cum[len(h.cum)-1]++
+		case prev != nil && slices.Equal(gram[:order], prev[:order]):
+			// New successor of the current state
+			h.next = append(h.next, gram[order])
+			h.cum = append(h.cum, h.cum[len(h.cum)-1]+1)
+		default:
+			// New state
+			h.states = append(h.states, gram[:order]...)
+			h.offsets = append(h.offsets, uint32(len(h.next)))
+			h.next = append(h.next, gram[order])
+			h.cum = append(h.cum, 1)
+		}
+		prev = gram
+	}
+	h.offsets = append(h.offsets, uint32(len(h.next)))
+	h.indexFirst()
+
+	return h, nil
+}
+
+// indexFirst builds the first-token index over the sorted states.
+func (h *Heffalump) indexFirst() {
+	h.first = make([]uint32, len(h.tokens)+1)
+	for i := 0; i < h.numStates(); i++ {
+		h.first[h.states[i*h.order]+1]++
+	}
+	for t := 1; t < len(h.first); t++ {
+		h.first[t] += h.first[t-1]
+	}
+}
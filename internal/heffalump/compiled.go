@@ -0,0 +1,275 @@
+package heffalump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Compiled chain file layout (all integers little-endian):
+//
+//	header   64 bytes, see below
+//	body     sections, each padded to a multiple of 8 bytes:
+//	           token offsets  [tokens+1]uint32 into the token text
+//	           token text     []byte
+//	           states         [states*order]uint32
+//	           first          [tokens+1]uint32
+//	           offsets        [states+1]uint32
+//	           next           [transitions]uint32
+//	           cum            [transitions]uint32
+//
+// Every section is a plain aligned array that decodes with a single copy.
+// The whole file is read into memory at load time; it is not mapped.
+const (
+	compiledMagic   = "VSCHAIN\x00"
+	compiledVersion = 1
+	headerSize      = 64
+)
+
+// ErrNotCompiled is returned when a file is not a compiled chain.
+var ErrNotCompiled = errors.New("heffalump: not a compiled chain file")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type header struct {
+	Magic       [8]byte
+	Version     uint32
+	Order       uint32
+	Tokens      uint32
+	States      uint32
+	Transitions uint32
+	TextSize    uint32
+	BodySize    uint64
+	Checksum    uint32 // CRC-32C of the body
+	_           [20]byte
+}
+
+// IsCompiled reports whether the file at path starts with the compiled chain magic.
+func IsCompiled(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(compiledMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(magic) == compiledMagic, nil
+}
+
+// Encode writes the chain in the compiled binary format.
+func (h *Heffalump) Encode(w io.Writer) error {
+	var text bytes.Buffer
+	tokenOffsets := make([]uint32, 0, len(h.tokens)+1)
+	for _, t := range h.tokens {
+		tokenOffsets = append(tokenOffsets, uint32(text.Len()))
+		text.WriteString(t)
+	}
+	tokenOffsets = append(tokenOffsets, uint32(text.Len()))
+
+	var body bytes.Buffer
+	writeSection(&body, u32Bytes(tokenOffsets))
+	writeSection(&body, text.Bytes())
+	writeSection(&body, u32Bytes(h.states))
+	writeSection(&body, u32Bytes(h.first))
+	writeSection(&body, u32Bytes(h.offsets))
+	writeSection(&body, u32Bytes(h.next))
+	writeSection(&body, u32Bytes(h.cum))
+
+	hdr := header{
+		Version:     compiledVersion,
+		Order:       uint32(h.order),
+		Tokens:      uint32(len(h.tokens)),
+		States:      uint32(h.numStates()),
+		Transitions: uint32(len(h.next)),
+		TextSize:    uint32(text.Len()),
+		BodySize:    uint64(body.Len()),
+		Checksum:    crc32.Checksum(body.Bytes(), crcTable),
+	}
+	copy(hdr.Magic[:], compiledMagic)
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if _, err := bw.Write(body.Bytes()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Save writes the compiled chain to path.
+func (h *Heffalump) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := h.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadCompiled reads a chain written by Encode.
+func loadCompiled(path string) (*Heffalump, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (*Heffalump, error) {
+	if len(data) < headerSize || string(data[:len(compiledMagic)]) != compiledMagic {
+		return nil, ErrNotCompiled
+	}
+
+	var hdr header
+	if err := binary.Read(bytes.NewReader(data[:headerSize]), binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Version != compiledVersion {
+		return nil, fmt.Errorf("heffalump: unsupported compiled chain version %d", hdr.Version)
+	}
+	if hdr.Order < 1 || hdr.Order > MaxOrder {
+		return nil, fmt.Errorf("heffalump: invalid order %d in compiled chain", hdr.Order)
+	}
+
+	body := data[headerSize:]
+	if uint64(len(body)) != hdr.BodySize {
+		return nil, fmt.Errorf("heffalump: compiled chain is truncated (%d of %d bytes)", len(body), hdr.BodySize)
+	}
+	if crc32.Checksum(body, crcTable) != hdr.Checksum {
+		return nil, errors.New("heffalump: compiled chain checksum mismatch")
+	}
+
+	r := sectionReader{data: body}
+	tokenOffsets := r.u32s(int(hdr.Tokens) + 1)
+	text := string(r.bytes(int(hdr.TextSize)))
+	h := &Heffalump{
+		order:   int(hdr.Order),
+		states:  r.u32s(int(hdr.States) * int(hdr.Order)),
+		first:   r.u32s(int(hdr.Tokens) + 1),
+		offsets: r.u32s(int(hdr.States) + 1),
+		next:    r.u32s(int(hdr.Transitions)),
+		cum:     r.u32s(int(hdr.Transitions)),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// All tokens share the single text allocation
+	h.tokens = make([]string, hdr.Tokens)
+	for i := range h.tokens {
+		start, end := tokenOffsets[i], tokenOffsets[i+1]
+		if start > end || int(end) > len(text) {
+			return nil, errors.New("heffalump: corrupt token table in compiled chain")
+		}
+		h.tokens[i] = text[start:end]
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// validate checks the invariants the generator relies on, so a corrupt
+// file fails at load time instead of panicking mid-stream.
+func (h *Heffalump) validate() error {
+	if len(h.offsets) == 0 || h.offsets[len(h.offsets)-1] != uint32(len(h.next)) {
+		return errors.New("heffalump: corrupt offsets in compiled chain")
+	}
+	for i := 0; i < h.numStates(); i++ {
+		lo, hi := h.offsets[i], h.offsets[i+1]
+		if lo >= hi {
+			return errors.New("heffalump: state without successors in compiled chain")
+		}
+		// Cumulative weights restart at every state and must strictly
+		// increase, or the weighted pick divides by zero or skips successors
+		prev := uint32(0)
+		for _, c := range h.cum[lo:hi] {
+			if c <= prev {
+				return errors.New("heffalump: corrupt weights in compiled chain")
+			}
+			prev = c
+		}
+	}
+	for _, id := range h.next {
+		if int(id) >= len(h.tokens) {
+			return errors.New("heffalump: token ID out of range in compiled chain")
+		}
+	}
+	for _, id := range h.states {
+		if int(id) >= len(h.tokens) {
+			return errors.New("heffalump: token ID out of range in compiled chain")
+		}
+	}
+	if len(h.first) == 0 || h.first[0] != 0 || h.first[len(h.first)-1] != uint32(h.numStates()) {
+		return errors.New("heffalump: corrupt token index in compiled chain")
+	}
+	for t := 1; t < len(h.first); t++ {
+		if h.first[t] < h.first[t-1] {
+			return errors.New("heffalump: corrupt token index in compiled chain")
+		}
+	}
+	return nil
+}
+
+// writeSection writes b padded with zeros to a multiple of 8 bytes.
+func writeSection(buf *bytes.Buffer, b []byte) {
+	buf.Write(b)
+	if pad := (8 - len(b)%8) % 8; pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+}
+
+func u32Bytes(v []uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+// sectionReader reads the padded sections written by writeSection.
+type sectionReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sectionReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	padded := n + (8-n%8)%8
+	if n < 0 || padded > len(r.data) {
+		r.err = errors.New("heffalump: compiled chain section out of bounds")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[padded:]
+	return b
+}
+
+func (r *sectionReader) u32s(n int) []uint32 {
+	b := r.bytes(4 * n)
+	if b == nil {
+		return nil
+	}
+	v := make([]uint32, n)
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return v
+}
@@ -1,128 +1,287 @@
 package heffalump
 
 import (
-	"bufio"
-	"math/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
-	"time"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	// DefaultOrder is the number of previous words that pick the next one.
+	DefaultOrder = 2
+	// MaxOrder is the highest supported chain order.
+	MaxOrder = 4
+)
+
+// Options configures how the chain is built.
+type Options struct {
+	// Order is the number of previous words used to pick the next one (1-4).
+	// Zero means DefaultOrder.
+	Order int
+}
+
 // Heffalump is a Markov Chain generator designed for high-performance text streaming.
+//
+// Tokens are interned to uint32 IDs and the chain is stored in a few flat
+// arrays instead of a map of strings, which keeps multi-megabyte corpora
+// in a small fraction of the memory. The chain is immutable once loaded,
+// so any number of Streams can read it concurrently without locking.
 type Heffalump struct {
-	chain map[string][]string // Key: "word1 word2", Value: ["word3", "word3", ...]
-	keys  []string            // Cache of keys for random starting points
-	mu    sync.RWMutex        // Mutex for thread-safe access (if we add dynamic learning later)
-	rnd   *rand.Rand
+	order  int
+	tokens []string // Token ID -> token text
+
+	// ids maps token text to token ID. Only Next needs it, so compiled
+	// chains build it lazily to keep startup fast.
+	ids     map[string]uint32
+	idsOnce sync.Once
+
+	// states holds every known window of `order` token IDs back to back,
+	// sorted lexicographically so a window can be found by binary search.
+	states []uint32
+	// The states starting with token t are states[first[t]:first[t+1]],
+	// which narrows the binary search down to a handful of candidates.
+	first []uint32
+	// The successors of state i are next[offsets[i]:offsets[i+1]].
+	offsets []uint32
+	next    []uint32
+	// cum holds the cumulative weights matching next, restarting for every state.
+	cum []uint32
 }
 
-// New creates a new Heffalump instance from a source text file.
+// New creates a new Heffalump instance from a source text file or a
+// compiled chain, using the default order for text.
 func New(path string) (*Heffalump, error) {
-	h := &Heffalump{
-		chain: make(map[string][]string),
-		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	return NewWithOptions(path, Options{})
+}
+
+// NewWithOptions creates a new Heffalump instance from a source text file
+// or a compiled chain (see Encode). Compiled chains carry their own order;
+// a conflicting opts.Order is an error.
+func NewWithOptions(path string, opts Options) (*Heffalump, error) {
+	compiled, err := IsCompiled(path)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := h.load(path); err != nil {
+	var h *Heffalump
+	if compiled {
+		h, err = loadCompiled(path)
+		if err == nil && opts.Order != 0 && opts.Order != h.order {
+			err = fmt.Errorf("heffalump: %s was compiled with order %d, not %d", path, h.order, opts.Order)
+		}
+	} else {
+		var file *os.File
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		h, err = Build(file, opts)
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	log.Info().
+		Str("path", path).
+		Bool("compiled", compiled).
+		Int("order", h.order).
+		Int("tokens", len(h.tokens)).
+		Int("states", h.numStates()).
+		Int("transitions", len(h.next)).
+		Msg("Heffalump Markov chain loaded")
 	return h, nil
 }
 
-// load reads the file and builds the Markov chain.
-func (h *Heffalump) load(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+// NewFromFiles creates a Heffalump instance from several raw text files,
+// combined into a single chain. A single path may also be a compiled chain.
+func NewFromFiles(paths []string, opts Options) (*Heffalump, error) {
+	if len(paths) == 1 {
+		return NewWithOptions(paths[0], opts)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("heffalump: no corpus files given")
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(ScanHTML)
+	// Separate the files so words don't merge across file boundaries
+	var readers []io.Reader
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		readers = append(readers, f, strings.NewReader("\n"))
+	}
 
-	var w1, w2 string
+	h, err := Build(io.MultiReader(readers...), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Int("files", len(paths)).
+		Int("order", h.order).
+		Int("tokens", len(h.tokens)).
+		Int("states", h.numStates()).
+		Int("transitions", len(h.next)).
+		Msg("Heffalump Markov chain loaded")
+	return h, nil
+}
 
-	// Initialize first two words
-	if scanner.Scan() {
-		w1 = scanner.Text()
+// Build tokenizes text from r with ScanHTML and builds a chain.
+func Build(r io.Reader, opts Options) (*Heffalump, error) {
+	if opts.Order == 0 {
+		opts.Order = DefaultOrder
 	}
-	if scanner.Scan() {
-		w2 = scanner.Text()
+	if opts.Order < 1 || opts.Order > MaxOrder {
+		return nil, fmt.Errorf("heffalump: order must be between 1 and %d, got %d", MaxOrder, opts.Order)
 	}
+	return build(r, opts.Order)
+}
+
+// Order returns the chain order.
+func (h *Heffalump) Order() int {
+	return h.order
+}
 
-	// Build the chain
-	for scanner.Scan() {
-		w3 := scanner.Text()
-		key := w1 + " " + w2
+// Next returns the next probable word based on the previous words. Only the
+// last Order() words are considered. If the sequence is unknown or a dead
+// end, it picks a random starting point.
+// It is safe for concurrent use, but per-connection generation should use a Stream.
+func (h *Heffalump) Next(words ...string) string {
+	h.idsOnce.Do(func() {
+		if h.ids == nil {
+			h.ids = make(map[string]uint32, len(h.tokens))
+			for id, t := range h.tokens {
+				h.ids[t] = uint32(id)
+			}
+		}
+	})
 
-		h.chain[key] = append(h.chain[key], w3)
+	var window [MaxOrder]uint32
+	if len(words) >= h.order {
+		for i, w := range words[len(words)-h.order:] {
+			id, ok := h.ids[w]
+			if !ok {
+				// Unknown word: an impossible ID makes step restart the flow
+				id = uint32(len(h.tokens))
+			}
+			window[i] = id
+		}
+	} else {
+		window[0] = uint32(len(h.tokens))
+	}
 
-		// Shift window
-		w1, w2 = w2, w3
+	id, ok := h.step(globalRand{}, &window)
+	if !ok {
+		return "void"
 	}
+	return h.tokens[id]
+}
 
-	if err := scanner.Err(); err != nil {
-		return err
+// Seed returns two consecutive words from a random position in the chain.
+func (h *Heffalump) Seed() (string, string) {
+	n := h.numStates()
+	if n == 0 {
+		return "the", "void"
 	}
 
-	// Cache keys for random access
-	h.keys = make([]string, 0, len(h.chain))
-	for k := range h.chain {
-		h.keys = append(h.keys, k)
+	var window [MaxOrder]uint32
+	copy(window[:], h.state(globalRand{}.IntN(n)))
+	if h.order >= 2 {
+		return h.tokens[window[h.order-2]], h.tokens[window[h.order-1]]
 	}
 
-	log.Info().Int("triplets", len(h.chain)).Msg("Heffalump Markov chain loaded")
-	return nil
+	first := h.tokens[window[0]]
+	id, _ := h.step(globalRand{}, &window)
+	return first, h.tokens[id]
 }
 
-// Next returns the next probable word based on the previous two words.
-// If the sequence (w1, w2) is unknown or a dead end, it picks a random starting point.
-func (h *Heffalump) Next(w1, w2 string) string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	key := w1 + " " + w2
-	candidates, ok := h.chain[key]
-
-	if !ok || len(candidates) == 0 {
-		// Dead end or unknown sequence, pick a random key to restart the flow
-		if len(h.keys) == 0 {
-			return "void"
-		}
-		randomKey := h.keys[h.rnd.Intn(len(h.keys))]
-		// To restart smoothly, we pretend we just saw the random key.
-		// We return the *first* word of that key to reset the caller's state eventually,
-		// or we can just return a random word from that key's candidates.
-		// Let's return a random candidate from a random key to ensure we always return a "next" word.
-		candidates = h.chain[randomKey]
+// NewStream creates a generator for a single connection. It owns its RNG
+// and word window, so it must not be shared between goroutines.
+func (h *Heffalump) NewStream() *Stream {
+	s := &Stream{
+		h:   h,
+		rnd: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+	if n := h.numStates(); n > 0 {
+		copy(s.window[:], h.state(s.rnd.IntN(n)))
 	}
+	return s
+}
 
-	return candidates[h.rnd.Intn(len(candidates))]
+func (h *Heffalump) numStates() int {
+	return len(h.offsets) - 1
 }
 
-// Seed returns a random pair of words to start the generation loop.
-func (h *Heffalump) Seed() (string, string) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// state returns the token IDs of state i.
+func (h *Heffalump) state(i int) []uint32 {
+	return h.states[i*h.order : (i+1)*h.order]
+}
 
-	if len(h.keys) == 0 {
-		return "the", "void"
+// find returns the index of the state matching window.
+func (h *Heffalump) find(window []uint32) (int, bool) {
+	if int(window[0]) >= len(h.tokens) {
+		return 0, false
+	}
+	lo, hi := int(h.first[window[0]]), int(h.first[window[0]+1])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return slices.Compare(h.state(lo+i), window) >= 0
+	})
+	return i, i < hi && slices.Equal(h.state(i), window)
+}
+
+// step picks the token following window and shifts it into the window.
+// Unknown windows restart the flow from a random state. It returns false
+// if the chain is empty.
+func (h *Heffalump) step(rnd intner, window *[MaxOrder]uint32) (uint32, bool) {
+	n := h.numStates()
+	if n <= 0 {
+		return 0, false
 	}
 
-	key := h.keys[h.rnd.Intn(len(h.keys))]
-	parts := strings.Split(key, " ")
-	if len(parts) == 2 {
-		return parts[0], parts[1]
+	w := window[:h.order]
+	i, ok := h.find(w)
+	if !ok {
+		// Dead end or unknown sequence, jump to a random state
+		i = rnd.IntN(n)
+		copy(w, h.state(i))
 	}
-	return "the", "void"
+
+	// Weighted pick: the first successor whose cumulative weight exceeds r
+	lo, hi := h.offsets[i], h.offsets[i+1]
+	cum := h.cum[lo:hi]
+	r := uint32(rnd.IntN(int(cum[len(cum)-1])))
+	j := sort.Search(len(cum), func(k int) bool { return cum[k] > r })
+	id := h.next[int(lo)+j]
+
+	// Shift window
+	copy(w, w[1:])
+	w[h.order-1] = id
+	return id, true
+}
+
+// intner is the subset of *rand.Rand used by the generator.
+type intner interface {
+	IntN(n int) int
 }
 
+// globalRand uses the concurrency-safe top-level functions of math/rand/v2.
+type globalRand struct{}
+
+func (globalRand) IntN(n int) int { return rand.IntN(n) }
+
 // ScanHTML is a basic split function for a Scanner that returns each
 // space-separated word of text or HTML tag, with surrounding spaces deleted.
 // It will never return an empty string. The definition of space is set by
@@ -0,0 +1,88 @@
+package heffalump
+
+import (
+	"io"
+	"math/rand/v2"
+)
+
+// streamChunkSize is the size of the chunks written by Stream.WriteTo.
+const streamChunkSize = 4096
+
+// Stream is a per-connection Markov chain walker. It implements io.Reader
+// and io.WriterTo, so an endless stream of text can be piped into a
+// connection with io.Copy.
+type Stream struct {
+	h      *Heffalump
+	rnd    *rand.Rand
+	window [MaxOrder]uint32 // Token IDs of the last Order() words
+
+	pending string // Part of the last word that did not fit into Read's buffer
+	buf     []byte // Scratch buffer for WriteTo, allocated on first use
+}
+
+// Next returns the next word of the stream and advances its window.
+func (s *Stream) Next() string {
+	id, ok := s.h.step(s.rnd, &s.window)
+	if !ok {
+		return "void"
+	}
+	return s.h.tokens[id]
+}
+
+// Read fills p with space-separated generated words. It never returns an error.
+func (s *Stream) Read(p []byte) (int, error) {
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	for n < len(p) {
+		word := s.Next()
+		c := copy(p[n:], word)
+		n += c
+		if c < len(word) {
+			s.pending = word[c:] + " "
+			break
+		}
+		if n < len(p) {
+			p[n] = ' '
+			n++
+		} else {
+			s.pending = " "
+		}
+	}
+	return n, nil
+}
+
+// AppendText appends whole space-terminated words to dst until at least
+// n bytes were added, and returns the extended slice.
+func (s *Stream) AppendText(dst []byte, n int) []byte {
+	target := len(dst) + n
+	for len(dst) < target {
+		dst = append(dst, s.Next()...)
+		dst = append(dst, ' ')
+	}
+	return dst
+}
+
+// WriteTo writes generated text to w in chunks until a write fails.
+// Since the stream is endless, it only ever returns with an error.
+func (s *Stream) WriteTo(w io.Writer) (int64, error) {
+	if s.buf == nil {
+		s.buf = make([]byte, 0, streamChunkSize+64)
+	}
+
+	var total int64
+	for {
+		// Start with whatever a previous Read left over
+		s.buf = append(s.buf[:0], s.pending...)
+		s.pending = ""
+
+		// Leave a little room so the last word rarely forces a reallocation
+		s.buf = s.AppendText(s.buf, streamChunkSize-64-len(s.buf))
+
+		n, err := w.Write(s.buf)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// sniffTimeout limits waiting for the first byte of a connection. Proxies
+	// send their header right away; a client that stays silent for longer is
+	// connected directly and waiting for the server to speak first.
+	sniffTimeout = time.Second
+	// headerTimeout limits waiting for the rest of the PROXY protocol header.
+	headerTimeout = 5 * time.Second
+)
+
+// Every PROXY protocol header starts with one of these.
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// maxV1Header is the longest v1 header allowed by the specification.
+const maxV1Header = 107
+
+var errNoHeader = errors.New("no PROXY protocol header")
+
+// Listener wraps ln so the remote address of every connection is the one
+// sent in its PROXY protocol (v1 or v2) header.
+//
+// Headers are only read from trusted proxies, so with no trusted proxies
+// configured every connection keeps its own address. Connections without a
+// header keep their address too, including trusted ones that send nothing
+// within sniffTimeout. Headers are read in the background, so a slow client
+// never holds up Accept.
+func Listener(ln net.Listener) net.Listener {
+	l := &listener{
+		Listener: ln,
+		conns:    make(chan net.Conn),
+		errc:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+type listener struct {
+	net.Listener
+	conns     chan net.Conn
+	errc      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.errc <- err
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *listener) handshake(conn net.Conn) {
+	if !Trusted(AddrIP(conn.RemoteAddr())) {
+		l.deliver(conn)
+		return
+	}
+
+	c, err := readHeader(conn)
+	if err != nil {
+		log.Debug().Err(err).Str("remote_addr", conn.RemoteAddr().String()).Msg("Invalid PROXY protocol header")
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	l.deliver(c)
+}
+
+func (l *listener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next connection whose header has been read.
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errc:
+		// Keep returning the error to later calls
+		l.errc <- err
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Connections still sending their header are dropped.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// Conn is a connection whose remote address comes from a PROXY header.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+// Read reads from the bytes buffered after the header, then the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address sent by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readHeader reads the PROXY header at the start of conn. Connections
+// without one, or silent for sniffTimeout, are returned with their own address.
+func readHeader(conn net.Conn) (*Conn, error) {
+	r := bufio.NewReader(conn)
+	c := &Conn{Conn: conn, r: r, remote: conn.RemoteAddr()}
+
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	if _, err := r.Peek(1); err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return c, nil
+		}
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	remote, err := parseHeader(r)
+	if errors.Is(err, errNoHeader) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		c.remote = remote
+	}
+	return c, nil
+}
+
+// parseHeader parses a v1 or v2 header. It returns a nil address for
+// headers that carry none, e.g. health checks of the proxy itself.
+func parseHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		// Clients that sent a few bytes which are not a header
+		if len(start) > 0 && !bytes.HasPrefix(v2Signature, start) && !bytes.HasPrefix(v1Prefix, start[:min(len(start), len(v1Prefix))]) {
+			return nil, errNoHeader
+		}
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return parseV2(r)
+	case bytes.HasPrefix(start, v1Prefix):
+		return parseV1(r)
+	}
+	return nil, errNoHeader
+}
+
+// parseV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1Header {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) < 2 {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 source: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// parseV2 parses the binary header of version 2.
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL: the proxy speaks for itself
+	if hdr[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address")
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[8:]))), nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address")
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[32:]))), nil
+	}
+	// UDP and UNIX sockets keep the address of the proxy
+	return nil, nil
+}
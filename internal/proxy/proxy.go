@@ -0,0 +1,145 @@
+// Package proxy recovers the real client address of connections that reach
+// the traps through a reverse proxy or load balancer, either from a PROXY
+// protocol header or from forwarding headers set by a trusted proxy.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+var trusted atomic.Pointer[[]netip.Prefix]
+
+// ParseTrusted parses a list of CIDRs. Plain IPs are single hosts.
+func ParseTrusted(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// SetTrusted sets the networks of the proxies whose PROXY protocol headers
+// and forwarding headers are believed. It can be changed at any time.
+func SetTrusted(prefixes []netip.Prefix) {
+	trusted.Store(&prefixes)
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		return p.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("trusted proxy %q: %w", s, err)
+	}
+	return netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()), nil
+}
+
+// Trusted reports whether ip belongs to a trusted proxy.
+func Trusted(ip netip.Addr) bool {
+	p := trusted.Load()
+	if p == nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range *p {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddrIP returns the IP of a TCP address, or the zero Addr.
+func AddrIP(addr net.Addr) netip.Addr {
+	return AddrPort(addr).Addr()
+}
+
+// AddrPort returns the IP and port of a TCP address, or the zero AddrPort.
+func AddrPort(addr net.Addr) netip.AddrPort {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ap := tcp.AddrPort()
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// ClientIP returns the IP of the client behind peer, the address the request
+// came from. Forwarding headers are only honoured when peer is a trusted
+// proxy. header returns the value of a request header.
+//
+// The forwarding chain is walked from the right, skipping trusted proxies,
+// since everything left of the first untrusted hop can be forged by the
+// client.
+func ClientIP(peer netip.Addr, header func(name string) string) netip.Addr {
+	if !Trusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	if v := header("Forwarded"); v != "" {
+		hops = forwardedFor(v)
+	} else if v := header("X-Forwarded-For"); v != "" {
+		hops = strings.Split(v, ",")
+	} else if v := header("X-Real-IP"); v != "" {
+		hops = []string{v}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !Trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the for= values of an RFC 7239 Forwarded header.
+func forwardedFor(v string) []string {
+	var hops []string
+	for _, elem := range strings.Split(v, ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, value)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses a forwarded address: an IP, optionally quoted, bracketed
+// or with a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
@@ -0,0 +1,294 @@
+// Package session tracks every trapped connection from accept to close:
+// how long it stayed, how much it was fed and why it ended.
+package session
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog/log"
+)
+
+// Reasons a session ended.
+const (
+	// EndClientClose means the client hung up.
+	EndClientClose = "client_close"
+	// EndTimeout means a read or write deadline passed.
+	EndTimeout = "timeout"
+	// EndShutdown means the trap was shut down.
+	EndShutdown = "shutdown"
+	// EndServerClose means the trap closed the connection, e.g. after a
+	// short response.
+	EndServerClose = "server_close"
+	// EndKicked means the connection was closed with Kick.
+	EndKicked = "kicked"
+)
+
+// Record describes a session that has ended, or one still open as returned
+// by Live.
+type Record struct {
+	ID                uint64        `json:"id"`
+	Trap              string        `json:"trap"`
+	RemoteAddr        string        `json:"remote_addr"`
+	Start             time.Time     `json:"start"`
+	End               time.Time     `json:"end,omitzero"`
+	Duration          time.Duration `json:"duration"`
+	BytesSent         int64         `json:"bytes_sent"`
+	BytesUncompressed int64         `json:"bytes_uncompressed"`
+	Requests          int64         `json:"requests"`
+	EndReason         string        `json:"end_reason,omitempty"`
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   []func(Record)
+
+	// live holds the open sessions by ID
+	liveMu sync.Mutex
+	live   = make(map[uint64]*Conn)
+	nextID atomic.Uint64
+)
+
+// OnEnd registers fn to be called with the record of every session that
+// ends. fn runs on the connection's goroutine and must not block.
+func OnEnd(fn func(Record)) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers = append(handlers, fn)
+}
+
+// Group holds the sessions of one trap.
+type Group struct {
+	trap     string
+	shutdown atomic.Bool
+	active   atomic.Int64
+}
+
+// NewGroup creates the session group of a trap of type trapType.
+func NewGroup(trapType string) *Group {
+	return &Group{trap: trapType}
+}
+
+// Shutdown marks the trap as shutting down, so sessions closed from now on
+// end with EndShutdown.
+func (g *Group) Shutdown() {
+	g.shutdown.Store(true)
+}
+
+// Active returns the number of open sessions of g.
+func (g *Group) Active() int {
+	return int(g.active.Load())
+}
+
+// Listener wraps ln so every accepted connection is a session of g.
+func (g *Group) Listener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, group: g}
+}
+
+type listener struct {
+	net.Listener
+	group *Group
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{Conn: conn, Session: &Session{id: nextID.Add(1), group: l.group, start: time.Now()}}
+	l.group.active.Add(1)
+	liveMu.Lock()
+	live[c.id] = c
+	liveMu.Unlock()
+	return c, nil
+}
+
+// Session counts what happens on one connection. A nil Session ignores
+// everything, so callers need not check whether a connection is tracked.
+type Session struct {
+	id    uint64
+	group *Group
+	start time.Time
+
+	bytesSent         atomic.Int64
+	bytesUncompressed atomic.Int64
+	requests          atomic.Int64
+	reason            atomic.Pointer[string]
+}
+
+// ID returns the ID of the session, or 0 for a nil Session.
+func (s *Session) ID() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.id
+}
+
+// AddRequest counts a request served on the session.
+func (s *Session) AddRequest() {
+	if s != nil {
+		s.requests.Add(1)
+	}
+}
+
+// AddUncompressed counts n bytes fed into a compressor before being sent,
+// e.g. by the gzip trap.
+func (s *Session) AddUncompressed(n int) {
+	if s != nil {
+		s.bytesUncompressed.Add(int64(n))
+	}
+}
+
+// noteError remembers why the connection is about to end.
+func (s *Session) noteError(err error) {
+	var reason string
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		reason = EndTimeout
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		reason = EndClientClose
+	default:
+		return
+	}
+	s.reason.CompareAndSwap(nil, &reason)
+}
+
+// snapshot describes the session as of now.
+func (s *Session) snapshot(remoteAddr string, now time.Time) Record {
+	return Record{
+		ID:                s.id,
+		Trap:              s.group.trap,
+		RemoteAddr:        remoteAddr,
+		Start:             s.start,
+		Duration:          now.Sub(s.start),
+		BytesSent:         s.bytesSent.Load(),
+		BytesUncompressed: s.bytesUncompressed.Load(),
+		Requests:          s.requests.Load(),
+	}
+}
+
+func (s *Session) end(remoteAddr string) {
+	end := time.Now()
+	r := s.snapshot(remoteAddr, end)
+	r.End, r.EndReason = end, EndServerClose
+	if reason := s.reason.Load(); reason != nil {
+		r.EndReason = *reason
+	} else if s.group.shutdown.Load() {
+		r.EndReason = EndShutdown
+	}
+
+	telemetry.SessionDuration.WithLabelValues(r.Trap).Observe(r.Duration.Seconds())
+	telemetry.SessionBytes.WithLabelValues(r.Trap).Observe(float64(r.BytesSent))
+	log.Info().
+		Str("trap", r.Trap).
+		Str("remote_addr", r.RemoteAddr).
+		Time("start", r.Start).
+		Dur("duration", r.Duration).
+		Int64("bytes_sent", r.BytesSent).
+		Int64("bytes_uncompressed", r.BytesUncompressed).
+		Int64("requests", r.Requests).
+		Str("end_reason", r.EndReason).
+		Msg("Session ended")
+
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	for _, fn := range handlers {
+		fn(r)
+	}
+}
+
+// Conn is a connection tracked as a session.
+type Conn struct {
+	net.Conn
+	*Session
+	closeOnce sync.Once
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.noteError(err)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytesSent.Add(int64(n))
+	if err != nil {
+		c.noteError(err)
+	}
+	return n, err
+}
+
+// Close closes the connection and ends the session.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		liveMu.Lock()
+		delete(live, c.id)
+		liveMu.Unlock()
+		c.group.active.Add(-1)
+		c.end(c.RemoteAddr().String())
+	})
+	return err
+}
+
+// NetConn returns the wrapped connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Live returns the open sessions, oldest first. End and EndReason are not
+// set, and Duration is the time connected so far.
+func Live() []Record {
+	now := time.Now()
+	liveMu.Lock()
+	records := make([]Record, 0, len(live))
+	for _, c := range live {
+		records = append(records, c.snapshot(c.RemoteAddr().String(), now))
+	}
+	liveMu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// Kick closes the open session id. It reports whether the session was found.
+func Kick(id uint64) bool {
+	liveMu.Lock()
+	c, ok := live[id]
+	liveMu.Unlock()
+	if !ok {
+		return false
+	}
+	reason := EndKicked
+	c.reason.Store(&reason)
+	c.Close()
+	return true
+}
+
+// From returns the session of conn, looking through wrappers such as
+// tls.Conn that expose a NetConn method. It returns nil for untracked
+// connections.
+func From(conn net.Conn) *Session {
+	for conn != nil {
+		if c, ok := conn.(*Conn); ok {
+			return c.Session
+		}
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = u.NetConn()
+	}
+	return nil
+}
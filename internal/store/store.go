@@ -0,0 +1,527 @@
+// Package store persists trap hits, sessions and captured credentials in an
+// embedded bbolt database, so history can be queried without shipping logs
+// elsewhere.
+//
+// Events are handed to a single writer goroutine through a buffered queue.
+// Recording never blocks: when the queue is full the event is dropped and
+// counted, so a slow disk can't stall the traps.
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kinds of events.
+const (
+	KindHit        = "hit"
+	KindSession    = "session"
+	KindCredential = "credential"
+)
+
+const (
+	defaultQueueSize     = 10000
+	defaultPruneInterval = time.Minute
+	// maxBatch caps the events written in one transaction.
+	maxBatch = 500
+	// maxPrune caps the events deleted in one transaction.
+	maxPrune = 5000
+	// defaultLimit caps query results unless the query sets a limit.
+	defaultLimit = 100
+)
+
+var (
+	bucketEvents = []byte("events")
+	bucketMeta   = []byte("meta")
+	keyCount     = []byte("count")
+
+	// Secondary indexes map <value> 0x00 <event key> to nothing.
+	indexIP   = []byte("by_ip")
+	indexTrap = []byte("by_trap")
+	indexKind = []byte("by_kind")
+)
+
+// Options configure the event store.
+type Options struct {
+	// Path of the database file. Empty disables the store.
+	Path string `koanf:"path"`
+	// Retention deletes events older than this. Zero keeps them forever.
+	Retention time.Duration `koanf:"retention"`
+	// MaxEvents deletes the oldest events beyond this count. Zero means no limit.
+	MaxEvents int `koanf:"max_events"`
+	// QueueSize is the number of events waiting to be written before new
+	// ones are dropped.
+	QueueSize int `koanf:"queue_size"`
+}
+
+// Event is something that happened in a trap.
+type Event struct {
+	// ID is assigned by the store and increases with every event.
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Trap is the trap type, e.g. "login_trap".
+	Trap string `json:"trap"`
+	// IP is the client address, resolved through trusted proxies where possible.
+	IP string `json:"ip"`
+
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Host      string `json:"host,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Fields holds request metadata such as client fingerprints and class.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Session is set on KindSession events.
+	Session *session.Record `json:"session,omitempty"`
+
+	// Username and Password are set on KindCredential events.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Store is an embedded event database. A nil Store records nothing.
+type Store struct {
+	db    *bolt.DB
+	opts  Options
+	queue chan Event
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Open opens or creates the database at opts.Path and starts its writer.
+// It returns nil if no path is configured.
+func Open(opts Options) (*Store, error) {
+	if opts.Path == "" {
+		return nil, nil
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if dir := filepath.Dir(opts.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, fmt.Errorf("creating store directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(opts.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening store %s: %w", opts.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEvents, bucketMeta, indexIP, indexTrap, indexKind} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing store: %w", err)
+	}
+
+	s := &Store{
+		db:     db,
+		opts:   opts,
+		queue:  make(chan Event, opts.QueueSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.prune()
+	go s.run()
+	return s, nil
+}
+
+// Record queues e to be written. It never blocks; if the queue is full the
+// event is dropped. A zero Time is set to now.
+func (s *Store) Record(e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	select {
+	case s.queue <- e:
+	default:
+		telemetry.StoreDropped.Inc()
+	}
+}
+
+// Close writes the queued events and closes the database.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() { close(s.closed) })
+	<-s.done
+	return s.db.Close()
+}
+
+// run writes queued events in batches and prunes old ones.
+func (s *Store) run() {
+	defer close(s.done)
+
+	prune := time.NewTicker(defaultPruneInterval)
+	defer prune.Stop()
+
+	batch := make([]Event, 0, maxBatch)
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch[:0], e)
+		drain:
+			for len(batch) < maxBatch {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e)
+				default:
+					break drain
+				}
+			}
+			s.write(batch)
+		case <-prune.C:
+			s.prune()
+		case <-s.closed:
+			for {
+				batch = batch[:0]
+				for len(batch) < maxBatch && len(s.queue) > 0 {
+					batch = append(batch, <-s.queue)
+				}
+				if len(batch) == 0 {
+					return
+				}
+				s.write(batch)
+			}
+		}
+	}
+}
+
+func (s *Store) write(batch []Event) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		events := tx.Bucket(bucketEvents)
+		for i := range batch {
+			e := &batch[i]
+			id, err := events.NextSequence()
+			if err != nil {
+				return err
+			}
+			e.ID = id
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			key := eventKey(e.Time, id)
+			if err := events.Put(key, data); err != nil {
+				return err
+			}
+			if err := putIndexes(tx, e, key); err != nil {
+				return err
+			}
+		}
+		return addCount(tx, int64(len(batch)))
+	})
+	if err != nil {
+		log.Error().Err(err).Int("events", len(batch)).Msg("Failed to write events to the store")
+		return
+	}
+	telemetry.StoreEvents.Add(float64(len(batch)))
+}
+
+// prune deletes events past the retention period or beyond MaxEvents.
+func (s *Store) prune() {
+	if s.opts.Retention <= 0 && s.opts.MaxEvents <= 0 {
+		return
+	}
+	var cutoff []byte
+	if s.opts.Retention > 0 {
+		cutoff = eventKey(time.Now().Add(-s.opts.Retention), 0)
+	}
+
+	for {
+		var deleted int
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			excess := int64(-1)
+			if s.opts.MaxEvents > 0 {
+				excess = count(tx) - int64(s.opts.MaxEvents)
+			}
+
+			c := tx.Bucket(bucketEvents).Cursor()
+			for k, v := c.First(); k != nil && deleted < maxPrune; k, v = c.First() {
+				expired := cutoff != nil && bytes.Compare(k, cutoff) < 0
+				if !expired && int64(deleted) >= excess {
+					break
+				}
+				var e Event
+				if err := json.Unmarshal(v, &e); err == nil {
+					if err := deleteIndexes(tx, &e, k); err != nil {
+						return err
+					}
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return addCount(tx, -int64(deleted))
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to prune the store")
+			return
+		}
+		if deleted > 0 {
+			telemetry.StorePruned.Add(float64(deleted))
+		}
+		if deleted < maxPrune {
+			return
+		}
+	}
+}
+
+// Query selects events. Empty fields match everything.
+type Query struct {
+	IP    string
+	Trap  string
+	Kind  string
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of events returned, newest first. Zero means 100.
+	Limit int
+}
+
+func (q Query) match(e *Event) bool {
+	return (q.IP == "" || e.IP == q.IP) &&
+		(q.Trap == "" || e.Trap == q.Trap) &&
+		(q.Kind == "" || e.Kind == q.Kind)
+}
+
+// Query returns the events matching q, newest first. It uses the index of
+// the IP, trap or kind, in that order, to avoid scanning every event.
+func (s *Store) Query(q Query) ([]Event, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	until := q.Until
+	if until.IsZero() {
+		until = time.Unix(0, 1<<63-1)
+	}
+	var since []byte
+	if !q.Since.IsZero() {
+		since = eventKey(q.Since, 0)
+	}
+
+	var results []Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(bucketEvents)
+		bucket, prefix := events, []byte(nil)
+		switch {
+		case q.IP != "":
+			bucket, prefix = tx.Bucket(indexIP), indexPrefix(q.IP)
+		case q.Trap != "":
+			bucket, prefix = tx.Bucket(indexTrap), indexPrefix(q.Trap)
+		case q.Kind != "":
+			bucket, prefix = tx.Bucket(indexKind), indexPrefix(q.Kind)
+		}
+
+		c := bucket.Cursor()
+		upper := append(append([]byte(nil), prefix...), eventKey(until, 1<<64-1)...)
+		k, v := c.Seek(upper)
+		if k == nil {
+			k, v = c.Last()
+		} else if bytes.Compare(k, upper) > 0 {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(results) < q.Limit; k, v = c.Prev() {
+			key := k[len(prefix):]
+			if since != nil && bytes.Compare(key, since) < 0 {
+				break
+			}
+			if prefix != nil {
+				v = events.Get(key)
+			}
+			var e Event
+			if v == nil || json.Unmarshal(v, &e) != nil {
+				continue
+			}
+			if q.match(&e) {
+				results = append(results, e)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// IPCount is the number of hits from one IP.
+type IPCount struct {
+	IP   string `json:"ip"`
+	Hits int64  `json:"hits"`
+}
+
+// TopIPs returns the limit IPs with the most hits since the given time,
+// most hits first. A zero since counts every stored hit.
+func (s *Store) TopIPs(since time.Time, limit int) ([]IPCount, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	var from []byte
+	if !since.IsZero() {
+		from = eventKey(since, 0)
+	}
+
+	counts := make(map[string]int64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		kinds := tx.Bucket(indexKind)
+		hit := indexPrefix(KindHit)
+		// Walk the IP index so no event has to be decoded
+		return tx.Bucket(indexIP).ForEach(func(k, _ []byte) error {
+			i := bytes.IndexByte(k, 0)
+			if i < 0 {
+				return nil
+			}
+			key := k[i+1:]
+			if from != nil && bytes.Compare(key, from) < 0 {
+				return nil
+			}
+			if kinds.Get(append(hit, key...)) == nil {
+				return nil
+			}
+			counts[string(k[:i])]++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	top := make([]IPCount, 0, len(counts))
+	for ip, n := range counts {
+		top = append(top, IPCount{IP: ip, Hits: n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Hits != top[j].Hits {
+			return top[i].Hits > top[j].Hits
+		}
+		return top[i].IP < top[j].IP
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// Count returns the number of stored events.
+func (s *Store) Count() (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = count(tx)
+		return nil
+	})
+	return n, err
+}
+
+// eventKey orders events by time, then by ID.
+func eventKey(t time.Time, id uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], id)
+	return key
+}
+
+func indexPrefix(value string) []byte {
+	return append([]byte(value), 0)
+}
+
+func indexEntries(e *Event) [][2][]byte {
+	return [][2][]byte{{indexIP, []byte(e.IP)}, {indexTrap, []byte(e.Trap)}, {indexKind, []byte(e.Kind)}}
+}
+
+func putIndexes(tx *bolt.Tx, e *Event, key []byte) error {
+	for _, idx := range indexEntries(e) {
+		if len(idx[1]) == 0 {
+			continue
+		}
+		if err := tx.Bucket(idx[0]).Put(append(indexPrefix(string(idx[1])), key...), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteIndexes(tx *bolt.Tx, e *Event, key []byte) error {
+	for _, idx := range indexEntries(e) {
+		if len(idx[1]) == 0 {
+			continue
+		}
+		if err := tx.Bucket(idx[0]).Delete(append(indexPrefix(string(idx[1])), key...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func count(tx *bolt.Tx) int64 {
+	v := tx.Bucket(bucketMeta).Get(keyCount)
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func addCount(tx *bolt.Tx, delta int64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(max(count(tx)+delta, 0)))
+	return tx.Bucket(bucketMeta).Put(keyCount, v)
+}
+
+var current atomic.Pointer[Store]
+
+// Use makes s the store that Record writes to. A nil s disables recording.
+func Use(s *Store) {
+	current.Store(s)
+}
+
+// Record queues e in the store set with Use, if any.
+func Record(e Event) {
+	current.Load().Record(e)
+}
+
+// RecordSession stores an ended session. Register it with session.OnEnd.
+func RecordSession(r session.Record) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	Record(Event{Time: r.End, Kind: KindSession, Trap: r.Trap, IP: ip, Session: &r})
+}
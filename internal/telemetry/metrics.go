@@ -1,6 +1,9 @@
 package telemetry
 
 import (
+	"io"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -29,4 +32,226 @@ var (
 		Name: "voidsink_credentials_captured_total",
 		Help: "The total number of credentials captured from login traps",
 	})
+
+	// MessagesCaptured tracks the number of spam messages accepted by the SMTP tarpit.
+	MessagesCaptured = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_messages_captured_total",
+		Help: "The total number of messages captured by the SMTP tarpit",
+	})
+
+	// MessagesCaptureSkipped tracks the messages not stored because the capture directory is full.
+	MessagesCaptureSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_messages_capture_skipped_total",
+		Help: "The total number of captured messages not stored because the capture directory is full",
+	})
+)
+
+var (
+	// GovernorConnections tracks the number of connections counted by the connection governor.
+	GovernorConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_governor_connections",
+		Help: "The number of connections currently counted against the connection limits",
+	})
+
+	// GovernorTrackedIPs tracks the number of distinct source IPs holding connections.
+	GovernorTrackedIPs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_governor_tracked_ips",
+		Help: "The number of distinct source IPs currently holding connections",
+	})
+
+	// GovernorTrackedPrefixes tracks the number of distinct network prefixes holding connections.
+	GovernorTrackedPrefixes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_governor_tracked_prefixes",
+		Help: "The number of distinct /24 or /64 prefixes currently holding connections",
+	})
+
+	// GovernorHeldConnections tracks over-limit connections held open without being served.
+	GovernorHeldConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_governor_held_connections",
+		Help: "The number of over-limit connections held open silently",
+	})
+
+	// GovernorOverflows tracks connections turned away by the connection governor.
+	GovernorOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_governor_overflows_total",
+		Help: "The total number of connections that exceeded a connection limit",
+	}, []string{"trap_type", "limit", "action"})
 )
+
+var (
+	// TrapsRunning tracks the number of trap instances currently accepting connections.
+	TrapsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_traps_running",
+		Help: "The number of trap instances currently accepting connections",
+	})
+
+	// ConfigReloads tracks configuration reloads by outcome (success or failure).
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_config_reloads_total",
+		Help: "The total number of configuration reloads",
+	}, []string{"result"})
+)
+
+var (
+	// TLSFingerprints tracks TLS connections by the JA4 fingerprint of the client.
+	// Use FingerprintLabel for the ja4 label to keep its cardinality bounded.
+	TLSFingerprints = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_tls_fingerprints_total",
+		Help: "The total number of TLS connections by JA4 client fingerprint",
+	}, []string{"trap_type", "ja4"})
+
+	// HTTPClients tracks HTTP requests by the class of client that sent them.
+	HTTPClients = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_http_clients_total",
+		Help: "The total number of HTTP requests by client class",
+	}, []string{"trap_type", "class"})
+)
+
+var (
+	// SessionDuration tracks how long trapped connections stay, from accept to close.
+	SessionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "voidsink_session_duration_seconds",
+		Help:    "How long trapped connections stayed connected",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms to about 7h
+	}, []string{"trap_type"})
+
+	// SessionBytes tracks the bytes written to each trapped connection.
+	SessionBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "voidsink_session_bytes",
+		Help:    "The number of bytes written to each trapped connection",
+		Buckets: prometheus.ExponentialBuckets(1024, 8, 10), // 1KiB to 128GiB
+	}, []string{"trap_type"})
+)
+
+var (
+	// StoreEvents tracks the events written to the event store.
+	StoreEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_store_events_total",
+		Help: "The total number of events written to the event store",
+	})
+
+	// StoreDropped tracks events dropped because the store queue was full.
+	StoreDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_store_dropped_total",
+		Help: "The total number of events dropped because the store could not keep up",
+	})
+
+	// StorePruned tracks events deleted by the retention policy.
+	StorePruned = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_store_pruned_total",
+		Help: "The total number of events deleted by the store retention policy",
+	})
+)
+
+var (
+	// NotifierQueueDepth tracks the alerts waiting for a notifier worker.
+	NotifierQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "voidsink_notifier_queue_depth",
+		Help: "The number of alerts waiting to be delivered",
+	})
+
+	// NotifierDelivered tracks the alerts delivered to each sink.
+	NotifierDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_notifier_delivered_total",
+		Help: "The total number of alerts delivered, by sink",
+	}, []string{"sink"})
+
+	// NotifierFailures tracks failed delivery attempts, including those retried later.
+	NotifierFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_notifier_failures_total",
+		Help: "The total number of failed alert delivery attempts, by sink",
+	}, []string{"sink"})
+
+	// NotifierDeadLetters tracks alerts given up on after the last attempt.
+	NotifierDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "voidsink_notifier_dead_letters_total",
+		Help: "The total number of alerts that could not be delivered after every retry, by sink",
+	}, []string{"sink"})
+
+	// NotifierSpooled tracks alerts written to the spool file to be sent after a restart.
+	NotifierSpooled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_notifier_spooled_total",
+		Help: "The total number of undelivered alerts written to the spool file",
+	})
+
+	// NotifierSuppressed tracks alerts held back by the notifier rate limit.
+	NotifierSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_notifier_suppressed_total",
+		Help: "The total number of alerts suppressed by the rate limit",
+	})
+
+	// NotifierDropped tracks alerts lost because they could not be delivered or spooled.
+	NotifierDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voidsink_notifier_dropped_total",
+		Help: "The total number of alerts lost without being delivered",
+	})
+)
+
+// CountBytesSent wraps w so every byte written through it is added to BytesSent.
+func CountBytesSent(w io.Writer) io.Writer {
+	return &bytesSentWriter{w: w}
+}
+
+type bytesSentWriter struct {
+	w io.Writer
+}
+
+func (c *bytesSentWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	BytesSent.Add(float64(n))
+	return n, err
+}
+
+// OtherLabel replaces label values once a bounded label is full.
+const OtherLabel = "other"
+
+// defaultMaxFingerprints is the number of distinct fingerprints exported
+// as label values unless configured otherwise.
+const defaultMaxFingerprints = 100
+
+var fingerprintLabels = &boundedLabel{max: defaultMaxFingerprints}
+
+// SetMaxFingerprints sets how many distinct fingerprints are exported as
+// label values. Later ones are counted as OtherLabel.
+func SetMaxFingerprints(n int) {
+	fingerprintLabels.setMax(n)
+}
+
+// FingerprintLabel returns fp if it is one of the first distinct
+// fingerprints seen, or OtherLabel once the limit is reached.
+func FingerprintLabel(fp string) string {
+	return fingerprintLabels.value(fp)
+}
+
+// boundedLabel hands out at most max distinct label values, so clients
+// can't blow up the number of series.
+type boundedLabel struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func (b *boundedLabel) setMax(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n <= 0 {
+		n = defaultMaxFingerprints
+	}
+	b.max = n
+}
+
+func (b *boundedLabel) value(v string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seen[v]; ok {
+		return v
+	}
+	if len(b.seen) >= b.max {
+		return OtherLabel
+	}
+	if b.seen == nil {
+		b.seen = make(map[string]struct{})
+	}
+	b.seen[v] = struct{}{}
+	return v
+}
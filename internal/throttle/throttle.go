@@ -0,0 +1,181 @@
+package throttle
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultChunkSize is used when a rate is set without an explicit chunk size.
+// Slower rates get one second's worth per chunk, so the stream still drips.
+const defaultChunkSize = 1024
+
+// ErrStopped is returned by writers whose done channel was closed while they
+// waited to send.
+var ErrStopped = errors.New("throttle: stream stopped")
+
+// Options configures how fast a single stream is allowed to write.
+type Options struct {
+	// BytesPerSecond caps the stream's throughput. Zero means unlimited.
+	BytesPerSecond int `koanf:"bytes_per_second"`
+	// ChunkSize is the number of bytes written (and flushed) at once. Zero
+	// means one second's worth, at most 1024 bytes.
+	ChunkSize int `koanf:"chunk_size"`
+	// Jitter adds a random delay of up to this duration before every chunk.
+	Jitter time.Duration `koanf:"jitter"`
+}
+
+// Budget is a token bucket shared by many writers, used to cap the total
+// egress of the process. A nil Budget is unlimited.
+type Budget struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBudget creates a shared budget of bytesPerSecond.
+// It returns nil (unlimited) if bytesPerSecond is not positive.
+func NewBudget(bytesPerSecond int) *Budget {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &Budget{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n bytes from the bucket and returns how long the caller has
+// to wait before it is allowed to send them.
+func (b *Budget) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Limiter hands out rate-limited writers for a trap. All writers of a
+// limiter share the global budget but get their own per-stream bucket.
+// A nil Limiter is unlimited.
+type Limiter struct {
+	opts   Options
+	global *Budget
+}
+
+// NewLimiter creates a limiter applying opts to every stream and drawing
+// from the shared global budget (which may be nil).
+// It returns nil if neither imposes any limit.
+func NewLimiter(opts Options, global *Budget) *Limiter {
+	if opts.BytesPerSecond <= 0 && opts.Jitter <= 0 && global == nil {
+		return nil
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+		if opts.BytesPerSecond > 0 {
+			opts.ChunkSize = min(opts.BytesPerSecond, defaultChunkSize)
+		}
+	}
+	return &Limiter{opts: opts, global: global}
+}
+
+// Writer wraps w so writes respect the limiter's rate, chunk size and jitter.
+// If w has a Flush method, it is called after every chunk so the data
+// actually leaves the process at the configured pace. Closing done cuts a
+// wait short and makes the write fail with ErrStopped; nil never does.
+func (l *Limiter) Writer(w io.Writer, done <-chan struct{}) io.Writer {
+	if l == nil {
+		return w
+	}
+	tw := &Writer{
+		w:      w,
+		done:   done,
+		opts:   l.opts,
+		local:  NewBudget(l.opts.BytesPerSecond),
+		global: l.global,
+	}
+	if tw.local != nil {
+		// Smooth drip: never allow more than one chunk to go out in a burst
+		tw.local.burst = float64(l.opts.ChunkSize)
+		tw.local.tokens = tw.local.burst
+	}
+	if f, ok := w.(flusher); ok {
+		tw.flusher = f
+	}
+	return tw
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Writer is a rate-limited io.Writer.
+type Writer struct {
+	w       io.Writer
+	flusher flusher
+	done    <-chan struct{}
+	opts    Options
+	local   *Budget
+	global  *Budget
+}
+
+// Write splits p into chunks and writes them at the configured pace.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.opts.ChunkSize {
+			chunk = chunk[:w.opts.ChunkSize]
+		}
+
+		wait := w.local.reserve(len(chunk))
+		if g := w.global.reserve(len(chunk)); g > wait {
+			wait = g
+		}
+		if w.opts.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(w.opts.Jitter)))
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-w.done:
+				t.Stop()
+				return written, ErrStopped
+			}
+		}
+
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if w.flusher != nil {
+			if err := w.flusher.Flush(); err != nil {
+				return written, err
+			}
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
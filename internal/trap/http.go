@@ -0,0 +1,365 @@
+package trap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/fingerprint"
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// HTTPOptions are the server settings shared by every HTTP trap. Embed it
+// with `koanf:",squash"` in a trap's options to expose them in the config.
+type HTTPOptions struct {
+	ListenOptions `koanf:",squash"`
+	// ServerName is sent in the Server header.
+	ServerName string `koanf:"server_name"`
+	// ReadTimeout limits reading a request, headers and body. Zero means no limit.
+	ReadTimeout time.Duration `koanf:"read_timeout"`
+	// WriteTimeout limits writing a response. Streaming traps leave it at zero.
+	WriteTimeout time.Duration `koanf:"write_timeout"`
+	// IdleTimeout limits waiting for the next keep-alive request.
+	IdleTimeout time.Duration `koanf:"idle_timeout"`
+	// MaxHeaderBytes caps the size of the request headers.
+	MaxHeaderBytes int `koanf:"max_header_bytes"`
+	// Concurrency caps the connections served at once. Zero means the fasthttp default.
+	Concurrency int `koanf:"concurrency"`
+	// TLS serves HTTPS instead of plain HTTP.
+	TLS TLSOptions `koanf:"tls"`
+}
+
+// DefaultHTTPOptions returns the settings used by the HTTP traps unless
+// configured otherwise. Trapped clients may stream forever, so there is no
+// write timeout.
+func DefaultHTTPOptions() HTTPOptions {
+	return HTTPOptions{
+		ReadTimeout:    10 * time.Second,
+		IdleTimeout:    30 * time.Second,
+		MaxHeaderBytes: 8 * 1024,
+	}
+}
+
+// httpOptions lets NewSpec find HTTPOptions embedded in a trap's options.
+func (o *HTTPOptions) httpOptions() *HTTPOptions {
+	return o
+}
+
+// restartKey returns the settings that are baked into the fasthttp server,
+// i.e. everything but the server name.
+func (o HTTPOptions) restartKey() HTTPOptions {
+	o.ServerName = ""
+	return o
+}
+
+// HTTPInfo describes an HTTP trap to its HTTPServer.
+type HTTPInfo struct {
+	// Type is the trap type, used as the trap_type label and by the governor.
+	Type string
+	// Name is the human readable name used in logs, e.g. "HTTP Infinite Trap".
+	Name string
+	// Alert sends a hit event to the notifier on every request. Traps that
+	// only alert on some requests leave it off and send their own events.
+	Alert bool
+	// Handler serves the request after it has been logged and counted.
+	Handler fasthttp.RequestHandler
+	// Quiet skips logging, counting and alerting, for handlers that only
+	// dispatch to other traps which do it themselves.
+	Quiet bool
+}
+
+// HTTPHandler is implemented by traps built on HTTPServer, so their request
+// handling can be mounted elsewhere, e.g. behind a router.
+type HTTPHandler interface {
+	Handler() fasthttp.RequestHandler
+}
+
+// HTTPServer runs a fasthttp server for an HTTP trap. It implements Trap,
+// so a trap only needs to supply a handler and embed the server.
+//
+// Cancelling the context of Start only closes the listener: clients that are
+// already trapped keep being served until Shutdown.
+type HTTPServer struct {
+	addr     string
+	info     HTTPInfo
+	server   *fasthttp.Server
+	tls      *tlsRuntime
+	opts     atomic.Pointer[HTTPOptions]
+	notifier *notifier.Notifier
+	governor *governor.Governor
+	sessions *session.Group
+}
+
+// NewHTTPServer creates an HTTPServer listening on addr. It fails if the
+// TLS certificates cannot be loaded or generated.
+func NewHTTPServer(addr string, opts HTTPOptions, info HTTPInfo, n *notifier.Notifier, g *governor.Governor) (*HTTPServer, error) {
+	s := &HTTPServer{
+		addr:     addr,
+		info:     info,
+		notifier: n,
+		governor: g,
+		sessions: session.NewGroup(info.Type),
+	}
+	if opts.TLS.Enabled {
+		t, err := newTLS(opts.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.tls = t
+	}
+	s.server = &fasthttp.Server{
+		Handler:      s.handle,
+		Name:         opts.ServerName,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+		// Headers have to fit in the read buffer, which makes it the header limit
+		ReadBufferSize: opts.MaxHeaderBytes,
+		Concurrency:    opts.Concurrency,
+		Logger:         fasthttpLogger{info.Type},
+	}
+	s.Reconfigure(opts)
+	return s, nil
+}
+
+// Reconfigure applies the server name to requests received from now on.
+// The other options, TLS included, are fixed once the server is created.
+func (s *HTTPServer) Reconfigure(opts HTTPOptions) {
+	s.opts.Store(&opts)
+}
+
+// Start starts the HTTP server and blocks until ctx is cancelled or the
+// server fails.
+func (s *HTTPServer) Start(ctx context.Context) error {
+	log.Info().Str("address", s.addr).Msg("Starting " + s.info.Name)
+
+	ln, err := Listen("tcp4", s.addr, s.opts.Load().ListenOptions)
+	if err != nil {
+		return err
+	}
+	if s.tls == nil {
+		ln = s.governor.Listener(ln, s.info.Type, governor.HTTPStaticResponse(s.opts.Load().ServerName))
+		ln = s.sessions.Listener(ln)
+	} else {
+		// A plain HTTP response means nothing to a TLS client, so the
+		// static overflow action falls back to rejecting
+		ln = s.governor.Listener(ln, s.info.Type, nil)
+		ln = s.sessions.Listener(ln)
+		ln = fingerprint.Listener(ln, func(fp *fingerprint.TLS) {
+			telemetry.TLSFingerprints.WithLabelValues(s.info.Type, telemetry.FingerprintLabel(fp.JA4)).Inc()
+		})
+		ln = tls.NewListener(ln, s.tls.config())
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.server.Serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		return <-errChan
+	case err := <-errChan:
+		return err
+	}
+}
+
+// Shutdown gracefully shuts down the server, waiting for trapped clients
+// until ctx expires.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	log.Info().Msg("Shutting down " + s.info.Name)
+	s.sessions.Shutdown()
+	if err := s.server.ShutdownWithContext(ctx); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Active returns the number of trapped clients.
+func (s *HTTPServer) Active() int {
+	return s.sessions.Active()
+}
+
+// Handler returns the full request handling of the trap, including logging,
+// telemetry and alerts. It works without the server being started.
+func (s *HTTPServer) Handler() fasthttp.RequestHandler {
+	return s.handle
+}
+
+// handle logs, counts and alerts on every request before passing it on.
+func (s *HTTPServer) handle(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.SetServer(s.opts.Load().ServerName)
+	if name := s.personaServerName(ctx); name != "" {
+		// Set last, so it also wins over the traps mounted by a router
+		defer ctx.Response.Header.SetServer(name)
+	}
+	if s.info.Quiet {
+		s.info.Handler(ctx)
+		return
+	}
+
+	path := string(ctx.Path())
+	client := ClientOf(ctx)
+
+	log.Info().
+		Str("trap", s.info.Type).
+		Str("method", string(ctx.Method())).
+		Str("path", path).
+		Str("remote_addr", ctx.RemoteAddr().String()).
+		EmbedObject(client).
+		Msg(s.info.Name + " hit")
+	SessionOf(ctx).AddRequest()
+	telemetry.TrapsTriggered.WithLabelValues(s.info.Type, path).Inc()
+	telemetry.HTTPClients.WithLabelValues(s.info.Type, client.HTTP.Class).Inc()
+	store.Record(RequestEvent(ctx, store.KindHit, s.info.Type, client))
+
+	if s.info.Alert {
+		s.notifier.Send(AlertEvent(ctx, notifier.KindHit, notifier.SeverityWarning, s.info.Type, client))
+	}
+
+	s.info.Handler(ctx)
+}
+
+// personaServerName returns the Server header of the TLS persona selected by
+// the client's SNI, if any.
+func (s *HTTPServer) personaServerName(ctx *fasthttp.RequestCtx) string {
+	if s.tls == nil {
+		return ""
+	}
+	state := ctx.TLSConnectionState()
+	if state == nil {
+		return ""
+	}
+	if p := s.tls.persona(state.ServerName); p != nil {
+		return p.serverName
+	}
+	return ""
+}
+
+// TLSFingerprint returns the JA3/JA4 fingerprint of the client, or nil for
+// plain HTTP.
+func TLSFingerprint(ctx *fasthttp.RequestCtx) *fingerprint.TLS {
+	tc, ok := ctx.Conn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	fc, ok := tc.NetConn().(*fingerprint.Conn)
+	if !ok {
+		return nil
+	}
+	return fc.TLS()
+}
+
+// SessionOf returns the session of the connection a request came in on, or
+// nil if it is not tracked, e.g. when the handler is called directly.
+func SessionOf(ctx *fasthttp.RequestCtx) *session.Session {
+	return session.From(ctx.Conn())
+}
+
+// Client describes the client of a request.
+type Client struct {
+	// IP is the address of the client, resolved through trusted proxies.
+	IP netip.Addr
+	// TLS is nil for plain HTTP.
+	TLS  *fingerprint.TLS
+	HTTP *fingerprint.HTTP
+}
+
+// ClientOf fingerprints and classifies the client of a request.
+func ClientOf(ctx *fasthttp.RequestCtx) Client {
+	headers := fingerprint.ParseHeaders(ctx.Request.Header.RawHeaders())
+	header := func(name string) string { return string(ctx.Request.Header.Peek(name)) }
+	return Client{
+		IP:   proxy.ClientIP(proxy.AddrIP(ctx.RemoteAddr()), header),
+		TLS:  TLSFingerprint(ctx),
+		HTTP: fingerprint.NewHTTP(string(ctx.Method()), string(ctx.Request.Header.Protocol()), headers),
+	}
+}
+
+// MarshalZerologObject adds the fingerprints and class to a log event.
+func (c Client) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("client_ip", c.IP.String()).EmbedObject(c.HTTP).EmbedObject(c.TLS)
+}
+
+// RequestEvent describes a request to the event store.
+func RequestEvent(ctx *fasthttp.RequestCtx, kind, trapType string, c Client) store.Event {
+	fields := make(map[string]string, 4)
+	if c.HTTP != nil {
+		fields["class"] = c.HTTP.Class
+		fields["ja4h"] = c.HTTP.JA4H
+	}
+	if c.TLS != nil {
+		fields["ja3"] = c.TLS.JA3
+		fields["ja4"] = c.TLS.JA4
+	}
+	return store.Event{
+		Kind:      kind,
+		Trap:      trapType,
+		IP:        c.IP.String(),
+		Method:    string(ctx.Method()),
+		Path:      string(ctx.Path()),
+		Host:      string(ctx.Host()),
+		UserAgent: string(ctx.UserAgent()),
+		Fields:    fields,
+	}
+}
+
+// AlertEvent describes a request to the notifier.
+func AlertEvent(ctx *fasthttp.RequestCtx, kind string, severity notifier.Severity, trapType string, c Client) notifier.Event {
+	e := notifier.Event{
+		Trap:      trapType,
+		Kind:      kind,
+		Severity:  severity,
+		IP:        c.IP.String(),
+		UserAgent: string(ctx.UserAgent()),
+		Method:    string(ctx.Method()),
+		Path:      string(ctx.Path()),
+		SessionID: SessionOf(ctx).ID(),
+	}
+	// The port of the connection is the client's only if no proxy is in between
+	if peer := proxy.AddrPort(ctx.RemoteAddr()); peer.Addr() == c.IP {
+		e.Port = int(peer.Port())
+	}
+	if c.HTTP != nil {
+		e.Fingerprint.Class = c.HTTP.Class
+		e.Fingerprint.JA4H = c.HTTP.JA4H
+	}
+	if c.TLS != nil {
+		e.Fingerprint.JA3 = c.TLS.JA3
+		e.Fingerprint.JA4 = c.TLS.JA4
+	}
+	return e
+}
+
+// StreamBody streams the response body with write, counting the connection
+// in ActiveConnections while it runs. write returns once the client is gone.
+func StreamBody(ctx *fasthttp.RequestCtx, write func(w *bufio.Writer)) {
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		telemetry.ActiveConnections.Inc()
+		defer telemetry.ActiveConnections.Dec()
+		write(w)
+	})
+}
+
+// fasthttpLogger routes fasthttp's own messages (e.g. malformed requests) to zerolog.
+type fasthttpLogger struct {
+	trapType string
+}
+
+func (l fasthttpLogger) Printf(format string, args ...interface{}) {
+	log.Debug().Str("trap", l.trapType).Msgf(format, args...)
+}
@@ -0,0 +1,38 @@
+package trap
+
+import (
+	"net"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+)
+
+// ListenOptions are the listener settings shared by every trap type. Embed
+// it with `koanf:",squash"` in a trap's options to expose them in the config.
+type ListenOptions struct {
+	// ProxyProtocol reads the client address from a PROXY protocol v1/v2
+	// header, for traps behind a load balancer such as HAProxy or nginx.
+	ProxyProtocol bool `koanf:"proxy_protocol"`
+}
+
+// listenOptions lets NewSpec find ListenOptions embedded in a trap's options.
+func (o *ListenOptions) listenOptions() *ListenOptions {
+	return o
+}
+
+// listenConfig is implemented by options that embed ListenOptions. The
+// listener is only created on start, so changes restart the trap.
+type listenConfig interface {
+	listenOptions() *ListenOptions
+}
+
+// Listen announces on the local network address like net.Listen and applies opts.
+func Listen(network, addr string, opts ListenOptions) (net.Listener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ProxyProtocol {
+		ln = proxy.Listener(ln)
+	}
+	return ln, nil
+}
@@ -0,0 +1,388 @@
+package trap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog/log"
+)
+
+// Spec describes a trap instance the Manager should be running.
+type Spec struct {
+	// Name identifies the instance across reloads.
+	Name string
+	// Type is the trap type, for display only.
+	Type string
+	// Addr is the listen address. Changing it restarts the instance.
+	Addr string
+	// Restart holds settings that cannot change on a running trap. Like
+	// Addr, any change to it restarts the instance.
+	Restart interface{}
+	// Options are the instance options as configured, for display only.
+	Options map[string]interface{}
+	// Config holds the settings the instance was built from. It is compared
+	// with reflect.DeepEqual to detect changes, so it should contain plain
+	// configuration values rather than loaded resources.
+	Config interface{}
+	// New creates the trap without starting it.
+	New func() (Trap, error)
+	// Reconfigure applies Config to a running trap created by an earlier
+	// spec with the same Name and Addr. If nil, config changes restart the trap.
+	Reconfigure func(Trap) error
+}
+
+// ApplyResult lists the instances touched by Manager.Apply.
+type ApplyResult struct {
+	Started      []string
+	Stopped      []string
+	Restarted    []string
+	Reconfigured []string
+}
+
+// Changed reports whether Apply did anything.
+func (r ApplyResult) Changed() bool {
+	return len(r.Started)+len(r.Stopped)+len(r.Restarted)+len(r.Reconfigured) > 0
+}
+
+const (
+	// DefaultRetireGrace is the RetireGrace of a new Manager.
+	DefaultRetireGrace = time.Hour
+	// drainPoll is how often a retired trap is checked for clients.
+	drainPoll = time.Second
+	// retireTimeout bounds the Shutdown of a retired trap.
+	retireTimeout = 5 * time.Second
+)
+
+// Manager runs a set of traps and applies configuration changes to it.
+//
+// Stopping a trap only closes its listener: connections that are already
+// trapped stay with the old instance. It is shut down in the background once
+// its last client leaves, or when RetireGrace has passed, or by Shutdown.
+type Manager struct {
+	// RetireGrace is how long a stopped trap keeps its clients. Traps that
+	// don't implement Drainer are always kept that long. Set it before the
+	// first Apply.
+	RetireGrace time.Duration
+
+	mu      sync.Mutex
+	running map[string]*instance
+	// retired maps the stopped traps still holding clients to a channel
+	// closed by Shutdown.
+	retired map[Trap]chan struct{}
+}
+
+type instance struct {
+	spec   Spec
+	trap   Trap
+	cancel context.CancelFunc
+	done   chan struct{}
+	// paused instances have closed their listener on request and are
+	// left alone by Apply until resumed
+	paused bool
+}
+
+// NewManager creates a Manager with no running traps.
+func NewManager() *Manager {
+	return &Manager{
+		RetireGrace: DefaultRetireGrace,
+		running:     make(map[string]*instance),
+		retired:     make(map[Trap]chan struct{}),
+	}
+}
+
+// Apply brings the running traps in line with specs. Instances are matched
+// by Name; missing ones are started, unknown ones stopped and changed ones
+// reconfigured or restarted. Instances whose Start failed are started again.
+//
+// If a trap cannot be created nothing is changed. A failed Reconfigure
+// leaves that instance on its previous settings and is reported in the error.
+func (m *Manager) Apply(specs []Spec) (ApplyResult, error) {
+	var result ApplyResult
+
+	wanted := make(map[string]Spec, len(specs))
+	for _, spec := range specs {
+		if _, ok := wanted[spec.Name]; ok {
+			return result, fmt.Errorf("trap: duplicate instance name %q", spec.Name)
+		}
+		wanted[spec.Name] = spec
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Create the new traps up front so a bad spec changes nothing
+	var start []Spec
+	var created []Trap
+	for _, spec := range specs {
+		if inst, ok := m.running[spec.Name]; ok && (inst.paused || !inst.needsRestart(spec)) {
+			continue
+		}
+		t, err := spec.New()
+		if err != nil {
+			return result, fmt.Errorf("trap %s: %w", spec.Name, err)
+		}
+		start = append(start, spec)
+		created = append(created, t)
+	}
+
+	// Stop first so restarted instances can bind their address again
+	for name, inst := range m.running {
+		if _, ok := wanted[name]; !ok {
+			m.stop(inst)
+			result.Stopped = append(result.Stopped, name)
+		}
+	}
+
+	var errs []error
+	for _, spec := range specs {
+		inst, ok := m.running[spec.Name]
+		switch {
+		case !ok:
+			result.Started = append(result.Started, spec.Name)
+		case inst.paused:
+			// Resume starts a new instance from the latest spec
+			inst.spec = spec
+		case inst.needsRestart(spec):
+			m.stop(inst)
+			result.Restarted = append(result.Restarted, spec.Name)
+		case !reflect.DeepEqual(inst.spec.Config, spec.Config):
+			if err := spec.Reconfigure(inst.trap); err != nil {
+				errs = append(errs, fmt.Errorf("trap %s: %w", spec.Name, err))
+				continue
+			}
+			inst.spec = spec
+			result.Reconfigured = append(result.Reconfigured, spec.Name)
+		}
+	}
+
+	for i, spec := range start {
+		m.start(spec, created[i])
+	}
+	return result, errors.Join(errs...)
+}
+
+// Len returns the number of running instances.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.running)
+}
+
+// ErrUnknownTrap is returned for instance names that are not running.
+var ErrUnknownTrap = errors.New("trap: no such instance")
+
+// Status describes a trap instance.
+type Status struct {
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	Addr    string                 `json:"addr"`
+	Options map[string]interface{} `json:"options"`
+	// Running is false for paused instances and ones whose Start failed.
+	Running bool `json:"running"`
+	Paused  bool `json:"paused"`
+}
+
+// Status returns the instances managed by m, sorted by name.
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := make([]Status, 0, len(m.running))
+	for _, inst := range m.running {
+		status = append(status, Status{
+			Name:    inst.spec.Name,
+			Type:    inst.spec.Type,
+			Addr:    inst.spec.Addr,
+			Options: inst.spec.Options,
+			Running: !inst.exited(),
+			Paused:  inst.paused,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// Pause closes the listener of the instance name. Trapped clients are kept,
+// and reloads leave the instance paused until Resume.
+func (m *Manager) Pause(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, ok := m.running[name]
+	if !ok {
+		return ErrUnknownTrap
+	}
+	if inst.paused {
+		return nil
+	}
+	inst.cancel()
+	<-inst.done
+	inst.paused = true
+	m.retire(inst.trap)
+	return nil
+}
+
+// Resume starts a paused instance again, or restarts one whose Start failed.
+func (m *Manager) Resume(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, ok := m.running[name]
+	if !ok {
+		return ErrUnknownTrap
+	}
+	if !inst.exited() {
+		return nil
+	}
+	t, err := inst.spec.New()
+	if err != nil {
+		return fmt.Errorf("trap %s: %w", name, err)
+	}
+	if !inst.paused {
+		m.retire(inst.trap)
+	}
+	m.start(inst.spec, t)
+	return nil
+}
+
+// Shutdown stops every instance and waits for trapped clients of running and
+// retired instances until ctx expires.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	for _, inst := range m.running {
+		m.stop(inst)
+	}
+	retired := m.retired
+	m.retired = make(map[Trap]chan struct{})
+	m.mu.Unlock()
+
+	var (
+		errsMu sync.Mutex
+		errs   []error
+		wg     sync.WaitGroup
+	)
+	for t, stop := range retired {
+		close(stop)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.Shutdown(ctx); err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// start runs t as the instance of spec. m.mu must be held.
+func (m *Manager) start(spec Spec, t Trap) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inst := &instance{
+		spec:   spec,
+		trap:   t,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.running[spec.Name] = inst
+
+	telemetry.TrapsRunning.Inc()
+	go func() {
+		defer close(inst.done)
+		defer telemetry.TrapsRunning.Dec()
+		if err := inst.trap.Start(ctx); err != nil {
+			log.Error().Err(err).Str("trap", spec.Name).Msg("Trap stopped with error")
+		}
+	}()
+}
+
+// stop closes the listener of inst and keeps the trap around so its clients
+// can be shut down later. m.mu must be held.
+func (m *Manager) stop(inst *instance) {
+	delete(m.running, inst.spec.Name)
+	if inst.paused {
+		// Already retired by Pause
+		return
+	}
+	inst.cancel()
+	<-inst.done
+	m.retire(inst.trap)
+}
+
+// retire shuts t down in the background once it holds no more clients or
+// RetireGrace has passed, unless Shutdown gets to it first. m.mu must be held.
+func (m *Manager) retire(t Trap) {
+	stop := make(chan struct{})
+	m.retired[t] = stop
+	go func() {
+		if !drain(t, m.RetireGrace, stop) {
+			return
+		}
+		m.mu.Lock()
+		_, ok := m.retired[t]
+		delete(m.retired, t)
+		m.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), retireTimeout)
+		defer cancel()
+		if err := t.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to shut down a stopped trap")
+		}
+	}()
+}
+
+// drain waits until t holds no more clients or grace has passed. It returns
+// false if stop is closed first.
+func drain(t Trap, grace time.Duration, stop <-chan struct{}) bool {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	d, ok := t.(Drainer)
+	if !ok {
+		select {
+		case <-deadline.C:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	for d.Active() > 0 {
+		select {
+		case <-tick.C:
+		case <-deadline.C:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// needsRestart reports whether spec can only be applied by a new instance.
+func (inst *instance) needsRestart(spec Spec) bool {
+	return inst.exited() ||
+		inst.spec.Addr != spec.Addr ||
+		!reflect.DeepEqual(inst.spec.Restart, spec.Restart) ||
+		(spec.Reconfigure == nil && !reflect.DeepEqual(inst.spec.Config, spec.Config))
+}
+
+// exited reports whether the instance stopped on its own, e.g. because its
+// address could not be bound.
+func (inst *instance) exited() bool {
+	select {
+	case <-inst.done:
+		return true
+	default:
+		return false
+	}
+}
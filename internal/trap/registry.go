@@ -0,0 +1,169 @@
+package trap
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Deps are the shared services handed to every trap instance.
+type Deps struct {
+	Notifier *notifier.Notifier
+	Governor *governor.Governor
+	// Egress is the bandwidth budget shared by all streaming traps.
+	Egress *throttle.Budget
+	// Corpus returns the named Heffalump corpus. An empty name means the default corpus.
+	Corpus func(name string) (*heffalump.Heffalump, error)
+}
+
+// Factory builds instances of one trap type.
+type Factory struct {
+	// Config returns a pointer to a new options struct with its defaults set.
+	// The instance options are decoded into it using `koanf` tags.
+	Config func() interface{}
+	// New creates a trap listening on addr from the decoded options.
+	New func(addr string, cfg interface{}, deps Deps) (Trap, error)
+	// Reconfigure applies decoded options to a trap created by New.
+	// If nil, option changes restart the trap.
+	Reconfigure func(t Trap, cfg interface{}, deps Deps) error
+	// UsesCorpus marks trap types that call Deps.Corpus, so the configuration
+	// is checked for the corpus they use.
+	UsesCorpus bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+func init() {
+	config.SetCorpusTypes(UsesCorpus)
+}
+
+// Register makes a trap type available under typ, the name used by the
+// `type` key of a trap instance. It is meant to be called from an init
+// function and panics if typ is registered twice.
+func Register(typ string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[typ]; ok {
+		panic("trap: Register called twice for type " + typ)
+	}
+	registry[typ] = f
+}
+
+// Types returns the registered trap types in sorted order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Build creates a trap of type typ from its raw options without going
+// through the Manager, e.g. to mount its handler somewhere else.
+func Build(typ, addr string, options map[string]interface{}, deps Deps) (Trap, error) {
+	f, ok := lookup(typ)
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q (known types: %v)", typ, Types())
+	}
+	cfg := f.Config()
+	if err := decodeOptions(options, cfg); err != nil {
+		return nil, err
+	}
+	return f.New(addr, cfg, deps)
+}
+
+// IsHTTP reports whether typ is an HTTP trap, i.e. its options embed HTTPOptions.
+func IsHTTP(typ string) bool {
+	f, ok := lookup(typ)
+	if !ok {
+		return false
+	}
+	_, ok = f.Config().(httpConfig)
+	return ok
+}
+
+// UsesCorpus reports whether typ is a trap type that streams a Heffalump corpus.
+func UsesCorpus(typ string) bool {
+	f, ok := lookup(typ)
+	return ok && f.UsesCorpus
+}
+
+func lookup(typ string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[typ]
+	return f, ok
+}
+
+// NewSpec decodes the options of a trap instance and describes it for the Manager.
+func NewSpec(c config.TrapConfig, deps Deps) (Spec, error) {
+	f, ok := lookup(c.Type)
+	if !ok {
+		return Spec{}, fmt.Errorf("traps.%s: unknown type %q (known types: %v)", c.Name, c.Type, Types())
+	}
+
+	cfg := f.Config()
+	if err := decodeOptions(c.Options, cfg); err != nil {
+		return Spec{}, fmt.Errorf("traps.%s: %w", c.Name, err)
+	}
+
+	spec := Spec{
+		Name:    c.Name,
+		Type:    c.Type,
+		Addr:    c.Addr,
+		Options: c.Options,
+		Config:  []interface{}{c.Type, cfg},
+		New: func() (Trap, error) {
+			return f.New(c.Addr, cfg, deps)
+		},
+	}
+	if h, ok := cfg.(httpConfig); ok {
+		spec.Restart = h.httpOptions().restartKey()
+	} else if l, ok := cfg.(listenConfig); ok {
+		spec.Restart = *l.listenOptions()
+	}
+	if f.Reconfigure != nil {
+		spec.Reconfigure = func(t Trap) error {
+			return f.Reconfigure(t, cfg, deps)
+		}
+	}
+	return spec, nil
+}
+
+// httpConfig is implemented by options that embed HTTPOptions. Their server
+// settings are fixed once the fasthttp server is created.
+type httpConfig interface {
+	httpOptions() *HTTPOptions
+}
+
+// decodeOptions decodes options into out the same way the rest of the
+// configuration is decoded, but rejects unknown keys to catch typos.
+func decodeOptions(options map[string]interface{}, out interface{}) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+			mapstructure.TextUnmarshallerHookFunc()),
+		Result:           out,
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		TagName:          "koanf",
+	})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(options)
+}
@@ -0,0 +1,209 @@
+package trap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultCertValidity is how long generated certificates are valid, like a
+// typical one year certificate.
+const defaultCertValidity = 365 * 24 * time.Hour
+
+// TLSOptions enables TLS on an HTTP trap.
+type TLSOptions struct {
+	Enabled bool `koanf:"enabled"`
+	// Certificate is served when no persona matches the SNI of the client.
+	Certificate `koanf:",squash"`
+	// Personas impersonate other hostnames on the same listener.
+	Personas []TLSPersona `koanf:"personas"`
+}
+
+// Certificate is loaded from CertFile and KeyFile. Without files a
+// self-signed certificate is generated at startup from the other fields.
+type Certificate struct {
+	CertFile string `koanf:"cert_file"`
+	KeyFile  string `koanf:"key_file"`
+	// CommonName defaults to the first SAN, or the host name of the machine.
+	CommonName   string   `koanf:"common_name"`
+	Organization string   `koanf:"organization"`
+	SANs         []string `koanf:"sans"`
+	// ValidFor defaults to a year.
+	ValidFor time.Duration `koanf:"valid_for"`
+}
+
+// TLSPersona is selected when the SNI of a client matches one of Hosts.
+type TLSPersona struct {
+	// Hosts are path.Match patterns, e.g. "*.example.com". They are the
+	// default SANs of a generated certificate.
+	Hosts []string `koanf:"hosts"`
+	// ServerName replaces the trap's Server header on these connections.
+	ServerName  string `koanf:"server_name"`
+	Certificate `koanf:",squash"`
+}
+
+// tlsRuntime holds the loaded certificates of a trap.
+type tlsRuntime struct {
+	cert     tls.Certificate
+	personas []persona
+}
+
+type persona struct {
+	hosts      []string
+	serverName string
+	cert       tls.Certificate
+}
+
+// newTLS loads or generates the certificates of opts.
+func newTLS(opts TLSOptions) (*tlsRuntime, error) {
+	cert, err := opts.Certificate.load(nil)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	t := &tlsRuntime{cert: cert}
+
+	for i, p := range opts.Personas {
+		if len(p.Hosts) == 0 {
+			return nil, fmt.Errorf("tls.personas[%d]: hosts is required", i)
+		}
+		hosts := make([]string, len(p.Hosts))
+		for j, h := range p.Hosts {
+			hosts[j] = strings.ToLower(h)
+			if _, err := path.Match(hosts[j], ""); err != nil {
+				return nil, fmt.Errorf("tls.personas[%d]: bad host pattern %q: %w", i, h, err)
+			}
+		}
+		cert, err := p.Certificate.load(hosts)
+		if err != nil {
+			return nil, fmt.Errorf("tls.personas[%d]: %w", i, err)
+		}
+		t.personas = append(t.personas, persona{hosts: hosts, serverName: p.ServerName, cert: cert})
+	}
+	return t, nil
+}
+
+// config returns the server configuration, picking the certificate by SNI.
+func (t *tlsRuntime) config() *tls.Config {
+	return &tls.Config{
+		// Old scanners are welcome too
+		MinVersion: tls.VersionTLS10,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if p := t.persona(hello.ServerName); p != nil {
+				return &p.cert, nil
+			}
+			return &t.cert, nil
+		},
+	}
+}
+
+// persona returns the persona whose hosts match sni, or nil.
+func (t *tlsRuntime) persona(sni string) *persona {
+	if sni == "" {
+		return nil
+	}
+	sni = strings.ToLower(sni)
+	for i := range t.personas {
+		for _, h := range t.personas[i].hosts {
+			if ok, _ := path.Match(h, sni); ok {
+				return &t.personas[i]
+			}
+		}
+	}
+	return nil
+}
+
+// load reads the certificate files or generates a self-signed certificate.
+// hosts are the default SANs.
+func (c Certificate) load(hosts []string) (tls.Certificate, error) {
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return tls.Certificate{}, errors.New("cert_file and key_file must be set together")
+		}
+		return tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	}
+	if len(c.SANs) == 0 {
+		c.SANs = hosts
+	}
+	return c.generate()
+}
+
+// generate creates a self-signed certificate that looks like one an admin
+// would make for their server.
+func (c Certificate) generate() (tls.Certificate, error) {
+	if c.CommonName == "" {
+		c.CommonName = firstPlainHost(c.SANs)
+	}
+	if c.CommonName == "" {
+		c.CommonName, _ = os.Hostname()
+	}
+	if len(c.SANs) == 0 && c.CommonName != "" {
+		c.SANs = []string{c.CommonName}
+	}
+	if c.ValidFor <= 0 {
+		c.ValidFor = defaultCertValidity
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	// A certificate issued seconds ago gives the trap away
+	notBefore := time.Now().Add(-time.Duration(mrand.Int64N(int64(90 * 24 * time.Hour)))).Truncate(time.Hour)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: c.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(c.ValidFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if c.Organization != "" {
+		tmpl.Subject.Organization = []string{c.Organization}
+	}
+	for _, san := range c.SANs {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// firstPlainHost returns the first host that is not a pattern or an IP.
+func firstPlainHost(hosts []string) string {
+	for _, h := range hosts {
+		if !strings.ContainsAny(h, "*?[") && net.ParseIP(h) == nil {
+			return h
+		}
+	}
+	return ""
+}
@@ -5,9 +5,16 @@ import "context"
 // Trap defines the interface that all honeypot services must implement.
 type Trap interface {
 	// Start starts the trap service. It should block until the service stops or fails.
-	// The context can be used to signal cancellation.
+	// Cancelling the context stops accepting new connections; clients that
+	// are already trapped are kept until Shutdown.
 	Start(ctx context.Context) error
 
 	// Shutdown gracefully shuts down the trap service.
 	Shutdown(ctx context.Context) error
 }
+
+// Drainer is implemented by traps that report how many clients they hold,
+// so a stopped instance can be shut down as soon as its last client leaves.
+type Drainer interface {
+	Active() int
+}
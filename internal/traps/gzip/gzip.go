@@ -3,10 +3,12 @@ package gziptrap
 import (
 	"bufio"
 	"compress/gzip"
-	"context"
-	"time"
+	"sync/atomic"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
 	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
 	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
@@ -14,82 +16,58 @@ import (
 
 // GzipTrap implements the Trap interface for a Gzip honeypot.
 type GzipTrap struct {
-	addr       string
-	serverName string
-	server     *fasthttp.Server
-	notifier   *notifier.Notifier
-	zeroBuf    []byte
+	*trap.HTTPServer
+	settings atomic.Pointer[settings]
+	zeroBuf  []byte
 }
 
-// New creates a new instance of GzipTrap.
-func New(addr, serverName string, n *notifier.Notifier) *GzipTrap {
-	// Pre-allocate a 32KB buffer of zeros to minimize allocations during the loop
-	return &GzipTrap{
-		addr:       addr,
-		serverName: serverName,
-		notifier:   n,
-		zeroBuf:    make([]byte, 32*1024),
-	}
+// settings holds what can change on a config reload. Every request works on
+// the snapshot it started with, so running streams are never disturbed.
+type settings struct {
+	limiter *throttle.Limiter
 }
 
-// Start starts the HTTP server.
-func (t *GzipTrap) Start(ctx context.Context) error {
-	t.server = &fasthttp.Server{
-		Handler:      t.requestHandler,
-		Name:         t.serverName,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 0, // Infinite
-		IdleTimeout:  30 * time.Second,
+// New creates a new instance of GzipTrap.
+// A nil limiter streams as fast as the socket allows.
+func New(addr string, opts trap.HTTPOptions, n *notifier.Notifier, l *throttle.Limiter, g *governor.Governor) (*GzipTrap, error) {
+	t := &GzipTrap{
+		// Pre-allocate a 32KB buffer of zeros to minimize allocations during the loop
+		zeroBuf: make([]byte, 32*1024),
 	}
-
-	log.Info().Str("address", t.addr).Msg("Starting Gzip Infinite Trap")
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe(t.addr)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return t.Shutdown(context.Background())
-	case err := <-errChan:
-		return err
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "gzip_infinite",
+		Name:    "Gzip Infinite Trap",
+		Alert:   true,
+		Handler: t.requestHandler,
+	}, n, g)
+	if err != nil {
+		return nil, err
 	}
+	t.HTTPServer = s
+	t.Reconfigure(opts, l)
+	return t, nil
 }
 
-// Shutdown gracefully shuts down the server.
-func (t *GzipTrap) Shutdown(ctx context.Context) error {
-	log.Info().Msg("Shutting down Gzip Infinite Trap")
-	if t.server != nil {
-		return t.server.Shutdown()
-	}
-	return nil
+// Reconfigure applies new settings to requests received from now on.
+func (t *GzipTrap) Reconfigure(opts trap.HTTPOptions, l *throttle.Limiter) {
+	t.HTTPServer.Reconfigure(opts)
+	t.settings.Store(&settings{limiter: l})
 }
 
 func (t *GzipTrap) requestHandler(ctx *fasthttp.RequestCtx) {
-	path := string(ctx.Path())
-	remoteIP := ctx.RemoteAddr().String()
-	userAgent := string(ctx.UserAgent())
-
-	log.Info().Str("path", path).Str("remote_addr", remoteIP).Msg("Gzip Trap hit")
-
-	telemetry.TrapsTriggered.WithLabelValues("gzip_infinite", path).Inc()
-
-	// Send Alert
-	if t.notifier != nil {
-		t.notifier.SendAlert("GzipInfinite", remoteIP, userAgent)
-	}
+	cfg := t.settings.Load()
 
 	ctx.SetContentType("text/plain")
 	ctx.Response.Header.Set("Content-Encoding", "gzip")
 
-	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
-		telemetry.ActiveConnections.Inc()
-		defer telemetry.ActiveConnections.Dec()
-
+	// The stream writer must not touch ctx, so look these up now
+	sess := trap.SessionOf(ctx)
+	done := ctx.Done()
+	trap.StreamBody(ctx, func(w *bufio.Writer) {
 		// Use BestCompression to maximize the expansion ratio (Gzip Bomb effect)
 		// This makes the client work hard to decompress while we send very little data.
-		gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		// The limiter sits below the compressor so it caps what actually goes on the wire.
+		gw, err := gzip.NewWriterLevel(cfg.limiter.Writer(w, done), gzip.BestCompression)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to create gzip writer")
 			return
@@ -108,6 +86,7 @@ func (t *GzipTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 			// We track the uncompressed bytes "sent" (generated)
 			// This represents the size of the data the attacker has to process.
 			telemetry.BytesSent.Add(float64(n))
+			sess.AddUncompressed(n)
 
 			// Flush the gzip writer to ensure data is pushed to the underlying writer
 			if err := gw.Flush(); err != nil {
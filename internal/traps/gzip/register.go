@@ -0,0 +1,29 @@
+package gziptrap
+
+import (
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of a gzip_infinite trap instance.
+type Config struct {
+	trap.HTTPOptions `koanf:",squash"`
+	Throttle         throttle.Options `koanf:"throttle"`
+}
+
+func init() {
+	trap.Register("gzip_infinite", trap.Factory{
+		Config: func() interface{} { return &Config{HTTPOptions: trap.DefaultHTTPOptions()} },
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			l := throttle.NewLimiter(c.Throttle, deps.Egress)
+			return New(addr, c.HTTPOptions, deps.Notifier, l, deps.Governor)
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			l := throttle.NewLimiter(c.Throttle, deps.Egress)
+			t.(*GzipTrap).Reconfigure(c.HTTPOptions, l)
+			return nil
+		},
+	})
+}
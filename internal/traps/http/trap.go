@@ -2,11 +2,14 @@ package httptrap
 
 import (
 	"bufio"
-	"context"
-	"time"
+	"io"
+	"sync/atomic"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
 	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
 	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
 	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
@@ -15,123 +18,61 @@ import (
 // HTTPInfiniteTrap implements the Trap interface for an HTTP honeypot
 // that streams infinite data to clients.
 type HTTPInfiniteTrap struct {
-	addr       string
-	serverName string
-	server     *fasthttp.Server
-	heffalump  *heffalump.Heffalump
-	pool       *heffalump.BufferPool
-	notifier   *notifier.Notifier
+	*trap.HTTPServer
+	settings atomic.Pointer[settings]
 }
 
-// New creates a new instance of HTTPInfiniteTrap.
-func New(addr, serverName string, h *heffalump.Heffalump, n *notifier.Notifier) *HTTPInfiniteTrap {
-	return &HTTPInfiniteTrap{
-		addr:       addr,
-		serverName: serverName,
-		heffalump:  h,
-		pool:       heffalump.NewBufferPool(),
-		notifier:   n,
-	}
+// settings holds what can change on a config reload. Every request works on
+// the snapshot it started with, so running streams are never disturbed.
+type settings struct {
+	heffalump *heffalump.Heffalump
+	limiter   *throttle.Limiter
 }
 
-// Start starts the HTTP server.
-func (t *HTTPInfiniteTrap) Start(ctx context.Context) error {
-	t.server = &fasthttp.Server{
+// New creates a new instance of HTTPInfiniteTrap.
+// A nil limiter streams as fast as the socket allows.
+func New(addr string, opts trap.HTTPOptions, h *heffalump.Heffalump, n *notifier.Notifier, l *throttle.Limiter, g *governor.Governor) (*HTTPInfiniteTrap, error) {
+	t := &HTTPInfiniteTrap{}
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "http_infinite",
+		Name:    "HTTP Infinite Trap",
+		Alert:   true,
 		Handler: t.requestHandler,
-		Name:    t.serverName,
-		// Increase timeouts to allow long-running connections (it's a trap after all)
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 0, // Infinite
-		IdleTimeout:  30 * time.Second,
-	}
-
-	log.Info().Str("address", t.addr).Msg("Starting HTTP Infinite Trap")
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe(t.addr)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return t.Shutdown(context.Background())
-	case err := <-errChan:
-		return err
+	}, n, g)
+	if err != nil {
+		return nil, err
 	}
+	t.HTTPServer = s
+	t.Reconfigure(opts, h, l)
+	return t, nil
 }
 
-// Shutdown gracefully shuts down the server.
-func (t *HTTPInfiniteTrap) Shutdown(ctx context.Context) error {
-	log.Info().Msg("Shutting down HTTP Infinite Trap")
-	if t.server != nil {
-		return t.server.Shutdown()
-	}
-	return nil
+// Reconfigure applies new settings to requests received from now on.
+func (t *HTTPInfiniteTrap) Reconfigure(opts trap.HTTPOptions, h *heffalump.Heffalump, l *throttle.Limiter) {
+	t.HTTPServer.Reconfigure(opts)
+	t.settings.Store(&settings{heffalump: h, limiter: l})
 }
 
 func (t *HTTPInfiniteTrap) requestHandler(ctx *fasthttp.RequestCtx) {
-	path := string(ctx.Path())
-	remoteIP := ctx.RemoteAddr().String()
-	userAgent := string(ctx.UserAgent())
-
-	log.Info().Str("path", path).Str("remote_addr", remoteIP).Msg("Trap hit")
-
-	telemetry.TrapsTriggered.WithLabelValues("http_infinite", path).Inc()
+	cfg := t.settings.Load()
 
-	// Send Alert
-	if t.notifier != nil {
-		t.notifier.SendAlert("HTTPInfinite", remoteIP, userAgent)
-	}
-
-	switch path {
+	switch string(ctx.Path()) {
 	case "/robots.txt":
 		ctx.SetContentType("text/plain")
 		ctx.WriteString("User-agent: *\nDisallow: /")
 	default:
 		// HellPot logic: Stream infinite Markov chain data
 		ctx.SetContentType("text/html")
-		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
-			telemetry.ActiveConnections.Inc()
-			defer telemetry.ActiveConnections.Dec()
-
-			// Get a buffer from the pool
-			buf := t.pool.Get()
-			defer t.pool.Put(buf)
-
-			// Seed the generator for this connection
-			w1, w2 := t.heffalump.Seed()
-
-			for {
-				// Fill the buffer with ~4KB of data
-				// We check buf.Len() < 4000 to leave a little room for the last word
-				for buf.Len() < 4000 {
-					w3 := t.heffalump.Next(w1, w2)
-					buf.WriteString(w3)
-					buf.WriteByte(' ') // Add space
-					w1, w2 = w2, w3
-				}
-
-				// Write the buffer to the network
-				n, err := w.Write(buf.Bytes())
-				if err != nil {
-					// Connection closed by client or error
-					log.Debug().Err(err).Msg("Connection closed during streaming")
-					return
-				}
-				telemetry.BytesSent.Add(float64(n))
-
-				// Flush to ensure data is sent
-				if err := w.Flush(); err != nil {
-					return
-				}
-
-				// Reset buffer for next iteration
-				buf.Reset()
-
-				// Optional: Small sleep to prevent 100% CPU usage if the network is too fast
-				// In a real honeypot, you might want to limit bitrate to keep them hooked longer
-				// without burning your own bandwidth.
-				// time.Sleep(50 * time.Millisecond)
+		// Closed on shutdown, ending throttled waits. The stream writer must not touch ctx.
+		done := ctx.Done()
+		trap.StreamBody(ctx, func(w *bufio.Writer) {
+			// Throttle the stream so we waste the attacker's time, not our bandwidth
+			out := telemetry.CountBytesSent(cfg.limiter.Writer(w, done))
+
+			// Each connection walks the chain with its own generator.
+			// The stream is endless, so this only returns once the client is gone.
+			if _, err := io.Copy(out, cfg.heffalump.NewStream()); err != nil {
+				log.Debug().Err(err).Msg("Connection closed during streaming")
 			}
 		})
 	}
@@ -0,0 +1,39 @@
+package jsontrap
+
+import (
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of a json_infinite trap instance.
+type Config struct {
+	trap.HTTPOptions `koanf:",squash"`
+	Throttle         throttle.Options `koanf:"throttle"`
+	Corpus           string           `koanf:"corpus"`
+}
+
+func init() {
+	trap.Register("json_infinite", trap.Factory{
+		Config:     func() interface{} { return &Config{HTTPOptions: trap.DefaultHTTPOptions()} },
+		UsesCorpus: true,
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			h, err := deps.Corpus(c.Corpus)
+			if err != nil {
+				return nil, err
+			}
+			l := throttle.NewLimiter(c.Throttle, deps.Egress)
+			return New(addr, c.HTTPOptions, h, deps.Notifier, l, deps.Governor)
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			h, err := deps.Corpus(c.Corpus)
+			if err != nil {
+				return err
+			}
+			l := throttle.NewLimiter(c.Throttle, deps.Egress)
+			t.(*JSONInfiniteTrap).Reconfigure(c.HTTPOptions, h, l)
+			return nil
+		},
+	})
+}
@@ -2,91 +2,67 @@ package jsontrap
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
 	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
 	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
-	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
 )
 
 // JSONInfiniteTrap implements the Trap interface for a JSON honeypot.
 type JSONInfiniteTrap struct {
-	addr       string
-	serverName string
-	server     *fasthttp.Server
-	heffalump  *heffalump.Heffalump
-	pool       *heffalump.BufferPool
-	notifier   *notifier.Notifier
+	*trap.HTTPServer
+	settings atomic.Pointer[settings]
+	pool     *heffalump.BufferPool
 }
 
-// New creates a new instance of JSONInfiniteTrap.
-func New(addr, serverName string, h *heffalump.Heffalump, n *notifier.Notifier) *JSONInfiniteTrap {
-	return &JSONInfiniteTrap{
-		addr:       addr,
-		serverName: serverName,
-		heffalump:  h,
-		pool:       heffalump.NewBufferPool(),
-		notifier:   n,
-	}
+// settings holds what can change on a config reload. Every request works on
+// the snapshot it started with, so running streams are never disturbed.
+type settings struct {
+	heffalump *heffalump.Heffalump
+	limiter   *throttle.Limiter
 }
 
-// Start starts the HTTP server.
-func (t *JSONInfiniteTrap) Start(ctx context.Context) error {
-	t.server = &fasthttp.Server{
-		Handler:      t.requestHandler,
-		Name:         t.serverName,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 0, // Infinite
-		IdleTimeout:  30 * time.Second,
-	}
-
-	log.Info().Str("address", t.addr).Msg("Starting JSON Infinite Trap")
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe(t.addr)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return t.Shutdown(context.Background())
-	case err := <-errChan:
-		return err
+// New creates a new instance of JSONInfiniteTrap.
+// A nil limiter streams as fast as the socket allows.
+func New(addr string, opts trap.HTTPOptions, h *heffalump.Heffalump, n *notifier.Notifier, l *throttle.Limiter, g *governor.Governor) (*JSONInfiniteTrap, error) {
+	t := &JSONInfiniteTrap{pool: heffalump.NewBufferPool()}
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "json_infinite",
+		Name:    "JSON Infinite Trap",
+		Alert:   true,
+		Handler: t.requestHandler,
+	}, n, g)
+	if err != nil {
+		return nil, err
 	}
+	t.HTTPServer = s
+	t.Reconfigure(opts, h, l)
+	return t, nil
 }
 
-// Shutdown gracefully shuts down the server.
-func (t *JSONInfiniteTrap) Shutdown(ctx context.Context) error {
-	log.Info().Msg("Shutting down JSON Infinite Trap")
-	if t.server != nil {
-		return t.server.Shutdown()
-	}
-	return nil
+// Reconfigure applies new settings to requests received from now on.
+func (t *JSONInfiniteTrap) Reconfigure(opts trap.HTTPOptions, h *heffalump.Heffalump, l *throttle.Limiter) {
+	t.HTTPServer.Reconfigure(opts)
+	t.settings.Store(&settings{heffalump: h, limiter: l})
 }
 
 func (t *JSONInfiniteTrap) requestHandler(ctx *fasthttp.RequestCtx) {
-	path := string(ctx.Path())
-	remoteIP := ctx.RemoteAddr().String()
-	userAgent := string(ctx.UserAgent())
-
-	log.Info().Str("path", path).Str("remote_addr", remoteIP).Msg("JSON Trap hit")
-
-	telemetry.TrapsTriggered.WithLabelValues("json_infinite", path).Inc()
-
-	// Send Alert
-	if t.notifier != nil {
-		t.notifier.SendAlert("JSONInfinite", remoteIP, userAgent)
-	}
+	cfg := t.settings.Load()
 
 	ctx.SetContentType("application/json")
-	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
-		telemetry.ActiveConnections.Inc()
-		defer telemetry.ActiveConnections.Dec()
+	// Closed on shutdown, ending throttled waits. The stream writer must not touch ctx.
+	done := ctx.Done()
+	trap.StreamBody(ctx, func(w *bufio.Writer) {
+		// Throttle the stream so we waste the attacker's time, not our bandwidth
+		out := cfg.limiter.Writer(w, done)
 
 		// Start JSON Array
 		w.WriteString("[\n")
@@ -100,30 +76,19 @@ func (t *JSONInfiniteTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 
 		var id uint64 = 1
 
-		// Seed the generator for this connection
-		w1, w2 := t.heffalump.Seed()
+		// Each connection walks the chain with its own generator
+		stream := cfg.heffalump.NewStream()
+		text := make([]byte, 0, 600)
 
 		for {
 			// Reset buffer for the new object
 			buf.Reset()
 
-			// Generate Heffalump text
-			// We use a separate buffer for the raw text to avoid mixing it with the JSON structure
-			textBuf := t.pool.Get()
-
 			// Generate ~500 bytes of text
-			for textBuf.Len() < 500 {
-				w3 := t.heffalump.Next(w1, w2)
-				textBuf.WriteString(w3)
-				textBuf.WriteByte(' ')
-				w1, w2 = w2, w3
-			}
+			text = stream.AppendText(text[:0], 500)
 
 			// Marshal just the string content to get proper JSON escaping (quotes included)
-			escapedJSONString, _ := json.Marshal(textBuf.String())
-
-			// We are done with textBuf
-			t.pool.Put(textBuf)
+			escapedJSONString, _ := json.Marshal(string(text))
 
 			// Construct the final JSON object
 			// {"id": <id>, "timestamp": "<time>", "data": <escaped_text>}
@@ -137,9 +102,11 @@ func (t *JSONInfiniteTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 			buf.WriteString(`},` + "\n")
 
 			// Write to the response stream
-			if _, err := w.Write(buf.Bytes()); err != nil {
+			n, err := out.Write(buf.Bytes())
+			if err != nil {
 				return // Client disconnected
 			}
+			telemetry.BytesSent.Add(float64(n))
 
 			if err := w.Flush(); err != nil {
 				return
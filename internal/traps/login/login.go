@@ -1,11 +1,10 @@
 package logintrap
 
 import (
-	"context"
-	"fmt"
-	"time"
-
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
 	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
 	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
@@ -40,83 +39,58 @@ const loginPageHTML = `<!DOCTYPE html>
 
 // LoginTrap implements the Trap interface for a fake login page.
 type LoginTrap struct {
-	addr       string
-	serverName string
-	server     *fasthttp.Server
-	notifier   *notifier.Notifier
+	*trap.HTTPServer
+	notifier *notifier.Notifier
 }
 
 // New creates a new instance of LoginTrap.
-func New(addr, serverName string, n *notifier.Notifier) *LoginTrap {
-	return &LoginTrap{
-		addr:       addr,
-		serverName: serverName,
-		notifier:   n,
+func New(addr string, opts trap.HTTPOptions, n *notifier.Notifier, g *governor.Governor) (*LoginTrap, error) {
+	t := &LoginTrap{notifier: n}
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type: "login_trap",
+		Name: "Login Trap",
+		// Only captured credentials are worth an alert
+		Handler: t.requestHandler,
+	}, n, g)
+	if err != nil {
+		return nil, err
 	}
+	t.HTTPServer = s
+	return t, nil
 }
 
-// Start starts the HTTP server.
-func (t *LoginTrap) Start(ctx context.Context) error {
-	t.server = &fasthttp.Server{
-		Handler:      t.requestHandler,
-		Name:         t.serverName,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
-	}
-
-	log.Info().Str("address", t.addr).Msg("Starting Login Trap")
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe(t.addr)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return t.Shutdown(context.Background())
-	case err := <-errChan:
-		return err
-	}
-}
-
-// Shutdown gracefully shuts down the server.
-func (t *LoginTrap) Shutdown(ctx context.Context) error {
-	log.Info().Msg("Shutting down Login Trap")
-	if t.server != nil {
-		return t.server.Shutdown()
-	}
-	return nil
+// Reconfigure applies new settings to requests received from now on.
+func (t *LoginTrap) Reconfigure(opts trap.HTTPOptions) {
+	t.HTTPServer.Reconfigure(opts)
 }
 
 func (t *LoginTrap) requestHandler(ctx *fasthttp.RequestCtx) {
-	path := string(ctx.Path())
 	remoteIP := ctx.RemoteAddr().String()
-	userAgent := string(ctx.UserAgent())
 	method := string(ctx.Method())
 
-	log.Info().Str("path", path).Str("method", method).Str("remote_addr", remoteIP).Msg("Login Trap hit")
-	telemetry.TrapsTriggered.WithLabelValues("login_trap", path).Inc()
-
 	if method == "POST" {
 		// Capture Credentials
 		username := string(ctx.FormValue("username"))
 		password := string(ctx.FormValue("password"))
 
 		if username != "" || password != "" {
+			client := trap.ClientOf(ctx)
 			log.Warn().
 				Str("username", username).
 				Str("password", password).
 				Str("remote_addr", remoteIP).
+				EmbedObject(client).
 				Msg("Credentials Captured")
 
 			telemetry.CredentialsCaptured.Inc()
 
-			if t.notifier != nil {
-				// High priority alert
-				// We pass the credentials in the "trapName" field so they appear in the alert
-				t.notifier.SendAlert(fmt.Sprintf("LoginTrap (User: %s, Pass: %s)", username, password), remoteIP, userAgent)
-			}
+			e := trap.RequestEvent(ctx, store.KindCredential, "login_trap", client)
+			e.Username, e.Password = username, password
+			store.Record(e)
+
+			alert := trap.AlertEvent(ctx, notifier.KindCredential, notifier.SeverityCritical, "login_trap", client)
+			alert.Credentials = &notifier.Credentials{Username: username, Password: password}
+			t.notifier.Send(alert)
 		}
 
 		// Return failure to encourage more tries
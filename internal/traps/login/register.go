@@ -0,0 +1,31 @@
+package logintrap
+
+import (
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of a login_trap trap instance.
+type Config struct {
+	trap.HTTPOptions `koanf:",squash"`
+}
+
+func init() {
+	trap.Register("login_trap", trap.Factory{
+		Config: func() interface{} {
+			opts := trap.DefaultHTTPOptions()
+			opts.WriteTimeout = 10 * time.Second
+			return &Config{HTTPOptions: opts}
+		},
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			return New(addr, c.HTTPOptions, deps.Notifier, deps.Governor)
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			t.(*LoginTrap).Reconfigure(c.HTTPOptions)
+			return nil
+		},
+	})
+}
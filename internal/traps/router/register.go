@@ -0,0 +1,81 @@
+package routertrap
+
+import (
+	"fmt"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// RouteConfig declares a route of an http_router trap instance.
+type RouteConfig struct {
+	Path    string   `koanf:"path"`
+	Host    string   `koanf:"host"`
+	Methods []string `koanf:"methods"`
+	// Trap is the type of the HTTP trap serving the route, e.g. login_trap.
+	Trap string `koanf:"trap"`
+	// Options are the options of that trap, as for a trap instance.
+	Options map[string]interface{} `koanf:"options"`
+}
+
+// Config holds the options of an http_router trap instance.
+type Config struct {
+	trap.HTTPOptions `koanf:",squash"`
+	Routes           []RouteConfig `koanf:"routes"`
+}
+
+// routes builds the trap of every route and mounts its handler. The traps are
+// never started, the router's listener serves them all.
+func (c *Config) routes(deps trap.Deps) ([]Route, error) {
+	routes := make([]Route, 0, len(c.Routes))
+	for i, rc := range c.Routes {
+		if rc.Trap == "" {
+			return nil, fmt.Errorf("routes[%d]: trap is required", i)
+		}
+		if !trap.IsHTTP(rc.Trap) {
+			return nil, fmt.Errorf("routes[%d]: %q is not an HTTP trap type (known types: %v)", i, rc.Trap, trap.Types())
+		}
+
+		options := make(map[string]interface{}, len(rc.Options)+1)
+		for k, v := range rc.Options {
+			options[k] = v
+		}
+		// All routes should look like the same server
+		if _, ok := options["server_name"]; !ok {
+			options["server_name"] = c.ServerName
+		}
+
+		t, err := trap.Build(rc.Trap, "", options, deps)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		routes = append(routes, Route{
+			Path:    rc.Path,
+			Host:    rc.Host,
+			Methods: rc.Methods,
+			Handler: t.(trap.HTTPHandler).Handler(),
+		})
+	}
+	return routes, nil
+}
+
+func init() {
+	trap.Register("http_router", trap.Factory{
+		Config: func() interface{} { return &Config{HTTPOptions: trap.DefaultHTTPOptions()} },
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			routes, err := c.routes(deps)
+			if err != nil {
+				return nil, err
+			}
+			return New(addr, c.HTTPOptions, routes, deps.Notifier, deps.Governor)
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			routes, err := c.routes(deps)
+			if err != nil {
+				return err
+			}
+			return t.(*Router).Reconfigure(c.HTTPOptions, routes)
+		},
+	})
+}
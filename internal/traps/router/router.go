@@ -0,0 +1,157 @@
+package routertrap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+const notFoundHTML = `<html>
+<head><title>404 Not Found</title></head>
+<body>
+<center><h1>404 Not Found</h1></center>
+</body>
+</html>`
+
+// Route sends matching requests to a trap handler. Empty fields match anything.
+type Route struct {
+	// Path is an exact path ("/wp-login.php"), a prefix ("/api/*" matches
+	// "/api" and everything below it) or a path.Match pattern ("/*.php").
+	Path string
+	// Host is a path.Match pattern for the Host header without port ("*.example.com").
+	Host string
+	// Methods lists the accepted request methods.
+	Methods []string
+	// Handler serves the matching requests.
+	Handler fasthttp.RequestHandler
+}
+
+// validate checks the patterns of the route.
+func (r *Route) validate() error {
+	if r.Handler == nil {
+		return errors.New("no handler")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+	for _, pattern := range []string{r.Path, r.Host} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (r *Route) match(ctx *fasthttp.RequestCtx) bool {
+	if len(r.Methods) > 0 {
+		method := ctx.Method()
+		found := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, string(method)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.Host != "" {
+		host := string(ctx.Host())
+		if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+			host = host[:i]
+		}
+		if ok, _ := path.Match(strings.ToLower(r.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+
+	if r.Path != "" {
+		p := ctx.Path()
+		if prefix, ok := strings.CutSuffix(r.Path, "/*"); ok {
+			return string(p) == prefix || bytes.HasPrefix(p, []byte(prefix+"/"))
+		}
+		if ok, _ := path.Match(r.Path, string(p)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Router mounts several HTTP trap handlers on one listener, so a single port
+// looks like a real site with a login page, an API and downloads.
+type Router struct {
+	*trap.HTTPServer
+	routes atomic.Pointer[[]Route]
+}
+
+// New creates a Router. Routes are tried in order and the first match wins.
+func New(addr string, opts trap.HTTPOptions, routes []Route, n *notifier.Notifier, g *governor.Governor) (*Router, error) {
+	r := &Router{}
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "http_router",
+		Name:    "HTTP Router",
+		Handler: r.requestHandler,
+		// The mounted traps log and alert themselves
+		Quiet: true,
+	}, n, g)
+	if err != nil {
+		return nil, err
+	}
+	r.HTTPServer = s
+	if err := r.Reconfigure(opts, routes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reconfigure replaces the routes for requests received from now on.
+func (r *Router) Reconfigure(opts trap.HTTPOptions, routes []Route) error {
+	for i := range routes {
+		if err := routes[i].validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	r.HTTPServer.Reconfigure(opts)
+	r.routes.Store(&routes)
+	return nil
+}
+
+func (r *Router) requestHandler(ctx *fasthttp.RequestCtx) {
+	routes := *r.routes.Load()
+	for i := range routes {
+		if route := &routes[i]; route.match(ctx) {
+			route.Handler(ctx)
+			return
+		}
+	}
+
+	path := string(ctx.Path())
+	client := trap.ClientOf(ctx)
+	log.Info().
+		Str("trap", "http_router").
+		Str("method", string(ctx.Method())).
+		Str("path", path).
+		Str("remote_addr", ctx.RemoteAddr().String()).
+		EmbedObject(client).
+		Msg("HTTP Router hit without a matching route")
+	store.Record(trap.RequestEvent(ctx, store.KindHit, "http_router", client))
+	trap.SessionOf(ctx).AddRequest()
+	telemetry.TrapsTriggered.WithLabelValues("http_router", path).Inc()
+
+	ctx.SetStatusCode(fasthttp.StatusNotFound)
+	ctx.SetContentType("text/html")
+	ctx.WriteString(notFoundHTML)
+}
@@ -0,0 +1,45 @@
+package smtptrap
+
+import (
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of an smtp_tarpit trap instance.
+type Config struct {
+	trap.ListenOptions `koanf:",squash"`
+	ServerName         string        `koanf:"server_name"`
+	Delay              time.Duration `koanf:"delay"`
+	GreetingLines      int           `koanf:"greeting_lines"`
+	CaptureDir         string        `koanf:"capture_dir"`
+	MaxMessageSize     int64         `koanf:"max_message_size"`
+	MaxCaptures        int           `koanf:"max_captures"`
+	MaxCaptureBytes    int64         `koanf:"max_capture_bytes"`
+}
+
+func (c *Config) options() Options {
+	return Options{
+		Hostname:        c.ServerName,
+		Delay:           c.Delay,
+		GreetingLines:   c.GreetingLines,
+		CaptureDir:      c.CaptureDir,
+		MaxMessageSize:  c.MaxMessageSize,
+		MaxCaptures:     c.MaxCaptures,
+		MaxCaptureBytes: c.MaxCaptureBytes,
+		Listen:          c.ListenOptions,
+	}
+}
+
+func init() {
+	trap.Register("smtp_tarpit", trap.Factory{
+		Config: func() interface{} { return &Config{} },
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			return New(addr, cfg.(*Config).options(), deps.Notifier, deps.Governor), nil
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			t.(*SMTPTarpit).Reconfigure(cfg.(*Config).options())
+			return nil
+		},
+	})
+}
@@ -0,0 +1,509 @@
+package smtptrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultDelay          = 5 * time.Second
+	defaultMaxMessageSize = 10 * 1024 * 1024
+	defaultMaxCaptures    = 10000
+	defaultMaxCaptureSize = 1024 * 1024 * 1024
+	commandTimeout        = 5 * time.Minute
+	// rescanInterval is how often a full capture directory is read again,
+	// to notice files removed by the operator.
+	rescanInterval = time.Minute
+)
+
+// SMTPTarpit implements the Trap interface for an SMTP tarpit.
+// It slowly drips a never-ending "220-" multiline greeting. Clients patient
+// enough to get past it are offered an open relay that accepts everything
+// and stores the spam payload on disk.
+type SMTPTarpit struct {
+	addr     string
+	opts     atomic.Pointer[Options]
+	notifier *notifier.Notifier
+	governor *governor.Governor
+	sessions *session.Group
+	usage    captureUsage
+
+	mu       sync.Mutex
+	listener net.Listener
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Options holds the tunables of the SMTP tarpit.
+type Options struct {
+	// Hostname is announced in the greeting and EHLO responses.
+	Hostname string
+	// Delay is the time between two greeting lines.
+	Delay time.Duration
+	// GreetingLines is the number of "220-" lines sent before the greeting
+	// completes. Zero means the greeting never completes.
+	GreetingLines int
+	// CaptureDir is where relayed messages are stored. Empty disables capture.
+	CaptureDir string
+	// MaxMessageSize caps the number of bytes stored per message.
+	MaxMessageSize int64
+	// MaxCaptures and MaxCaptureBytes cap the files and bytes in CaptureDir.
+	// Messages beyond them are accepted but not stored. Zero means 10000
+	// files and 1 GiB.
+	MaxCaptures     int
+	MaxCaptureBytes int64
+	// Listen is applied when the trap starts.
+	Listen trap.ListenOptions
+}
+
+// New creates a new instance of SMTPTarpit.
+func New(addr string, opts Options, n *notifier.Notifier, g *governor.Governor) *SMTPTarpit {
+	t := &SMTPTarpit{
+		addr:     addr,
+		notifier: n,
+		governor: g,
+		sessions: session.NewGroup("smtp_tarpit"),
+		quit:     make(chan struct{}),
+	}
+	t.Reconfigure(opts)
+	return t
+}
+
+// Reconfigure applies new options to connections accepted from now on.
+// Clients that are already trapped keep the options they started with.
+func (t *SMTPTarpit) Reconfigure(opts Options) {
+	if opts.Hostname == "" {
+		opts.Hostname = "mail.localdomain"
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = defaultDelay
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = defaultMaxMessageSize
+	}
+	if opts.MaxCaptures <= 0 {
+		opts.MaxCaptures = defaultMaxCaptures
+	}
+	if opts.MaxCaptureBytes <= 0 {
+		opts.MaxCaptureBytes = defaultMaxCaptureSize
+	}
+	t.opts.Store(&opts)
+}
+
+// Start starts the TCP listener and accepts connections until the context is cancelled.
+// Cancelling ctx only closes the listener; trapped clients are kept until Shutdown.
+func (t *SMTPTarpit) Start(ctx context.Context) error {
+	if dir := t.opts.Load().CaptureDir; dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("creating capture directory: %w", err)
+		}
+	}
+
+	ln, err := trap.Listen("tcp", t.addr, t.opts.Load().Listen)
+	if err != nil {
+		return err
+	}
+	ln = t.governor.Listener(ln, "smtp_tarpit", []byte("421 4.7.0 Too many connections, try again later\r\n"))
+	ln = t.sessions.Listener(ln)
+	t.mu.Lock()
+	t.listener = ln
+	t.mu.Unlock()
+
+	log.Info().Str("address", t.addr).Msg("Starting SMTP Tarpit")
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- t.serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		return <-errChan
+	case err := <-errChan:
+		return err
+	}
+}
+
+// Shutdown closes the listener and disconnects all trapped clients.
+func (t *SMTPTarpit) Shutdown(ctx context.Context) error {
+	log.Info().Msg("Shutting down SMTP Tarpit")
+	t.sessions.Shutdown()
+	t.quitOnce.Do(func() { close(t.quit) })
+
+	t.mu.Lock()
+	ln := t.listener
+	t.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// Active returns the number of trapped clients.
+func (t *SMTPTarpit) Active() int {
+	return t.sessions.Active()
+}
+
+func (t *SMTPTarpit) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.handleConn(conn)
+		}()
+	}
+}
+
+func (t *SMTPTarpit) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	remoteIP := conn.RemoteAddr().String()
+
+	log.Info().Str("remote_addr", remoteIP).Msg("SMTP Tarpit hit")
+	telemetry.TrapsTriggered.WithLabelValues("smtp_tarpit", "").Inc()
+	addr := proxy.AddrPort(conn.RemoteAddr())
+	store.Record(store.Event{Kind: store.KindHit, Trap: "smtp_tarpit", IP: addr.Addr().String()})
+
+	t.notifier.Send(notifier.Event{
+		Trap:      "smtp_tarpit",
+		Kind:      notifier.KindHit,
+		Severity:  notifier.SeverityWarning,
+		IP:        addr.Addr().String(),
+		Port:      int(addr.Port()),
+		SessionID: session.From(conn).ID(),
+	})
+
+	telemetry.ActiveConnections.Inc()
+	defer telemetry.ActiveConnections.Dec()
+
+	// Close the connection on shutdown so blocked reads return
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-t.quit:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	opts := t.opts.Load()
+	if !t.greet(conn, opts) {
+		return
+	}
+
+	t.relay(conn, remoteIP, opts)
+}
+
+// greet drips the multiline greeting. It returns false if the client went
+// away or the greeting never completes.
+func (t *SMTPTarpit) greet(conn net.Conn, opts *Options) bool {
+	ticker := time.NewTicker(opts.Delay)
+	defer ticker.Stop()
+
+	for sent := 0; opts.GreetingLines <= 0 || sent < opts.GreetingLines; sent++ {
+		select {
+		case <-t.quit:
+			return false
+		case <-ticker.C:
+		}
+
+		n, err := fmt.Fprintf(conn, "220-%s %s\r\n", opts.Hostname, randomWords())
+		if err != nil {
+			return false
+		}
+		telemetry.BytesSent.Add(float64(n))
+	}
+
+	n, err := fmt.Fprintf(conn, "220 %s ESMTP Postfix\r\n", opts.Hostname)
+	if err != nil {
+		return false
+	}
+	telemetry.BytesSent.Add(float64(n))
+	return true
+}
+
+// envelope holds the state of the current mail transaction.
+type envelope struct {
+	helo string
+	from string
+	to   []string
+}
+
+// relay speaks just enough SMTP to look like an open relay.
+func (t *SMTPTarpit) relay(conn net.Conn, remoteIP string, opts *Options) {
+	tp := textproto.NewConn(conn)
+	sess := session.From(conn)
+	var env envelope
+
+	reply := func(format string, args ...interface{}) bool {
+		if err := tp.PrintfLine(format, args...); err != nil {
+			return false
+		}
+		return true
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		sess.AddRequest()
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			env = envelope{helo: arg}
+			if !reply("250 %s", opts.Hostname) {
+				return
+			}
+		case "EHLO":
+			env = envelope{helo: arg}
+			if !reply("250-%s\r\n250-PIPELINING\r\n250-SIZE %d\r\n250-8BITMIME\r\n250 SMTPUTF8", opts.Hostname, opts.MaxMessageSize) {
+				return
+			}
+		case "MAIL":
+			env.from = addressArg(arg)
+			env.to = nil
+			if !reply("250 2.1.0 Ok") {
+				return
+			}
+		case "RCPT":
+			if env.from == "" {
+				if !reply("503 5.5.1 Error: need MAIL command") {
+					return
+				}
+				continue
+			}
+			env.to = append(env.to, addressArg(arg))
+			if !reply("250 2.1.5 Ok") {
+				return
+			}
+		case "DATA":
+			if len(env.to) == 0 {
+				if !reply("503 5.5.1 Error: need RCPT command") {
+					return
+				}
+				continue
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			if err := t.capture(tp, &env, remoteIP, opts); err != nil {
+				return
+			}
+			if !reply("250 2.0.0 Ok: queued as %X", rand.Uint32()) {
+				return
+			}
+			env = envelope{helo: env.helo}
+		case "RSET":
+			env = envelope{helo: env.helo}
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "NOOP":
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "VRFY":
+			if !reply("252 2.0.0 %s", arg) {
+				return
+			}
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			if !reply("502 5.5.2 Error: command not recognized") {
+				return
+			}
+		}
+	}
+}
+
+// capture reads the message body and stores it in the capture directory.
+func (t *SMTPTarpit) capture(tp *textproto.Conn, env *envelope, remoteIP string, opts *Options) error {
+	dr := tp.DotReader()
+	body, err := io.ReadAll(io.LimitReader(dr, opts.MaxMessageSize))
+	if err != nil {
+		return err
+	}
+	// Drain anything over the size limit so the session stays in sync
+	if _, err := io.Copy(io.Discard, dr); err != nil {
+		return err
+	}
+
+	log.Warn().
+		Str("remote_addr", remoteIP).
+		Str("helo", env.helo).
+		Str("from", env.from).
+		Strs("to", env.to).
+		Int("size", len(body)).
+		Msg("Spam Captured")
+
+	telemetry.MessagesCaptured.Inc()
+
+	if opts.CaptureDir == "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(remoteIP)
+	if err != nil {
+		host = remoteIP
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), strings.ReplaceAll(host, ":", "-"))
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "X-VoidSink-Remote-Addr: %s\r\n", remoteIP)
+	fmt.Fprintf(&buf, "X-VoidSink-Helo: %s\r\n", env.helo)
+	fmt.Fprintf(&buf, "X-VoidSink-Mail-From: %s\r\n", env.from)
+	for _, rcpt := range env.to {
+		fmt.Fprintf(&buf, "X-VoidSink-Rcpt-To: %s\r\n", rcpt)
+	}
+	buf.Write(body)
+
+	// The directory may have changed on a reload since Start created it
+	if err := os.MkdirAll(opts.CaptureDir, 0750); err != nil {
+		log.Error().Err(err).Msg("Failed to create capture directory")
+		return nil
+	}
+	// Still answer as a relay when full, only the file is skipped
+	if !t.usage.reserve(opts.CaptureDir, int64(buf.Len()), opts.MaxCaptures, opts.MaxCaptureBytes) {
+		telemetry.MessagesCaptureSkipped.Inc()
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(opts.CaptureDir, name), []byte(buf.String()), 0600); err != nil {
+		// A failed write is our problem, not the client's. Keep pretending.
+		log.Error().Err(err).Msg("Failed to store captured message")
+	}
+	return nil
+}
+
+// captureUsage tracks the files in the capture directory, so a client
+// relaying in a loop can't fill the disk.
+type captureUsage struct {
+	mu      sync.Mutex
+	dir     string
+	files   int
+	bytes   int64
+	scanned time.Time
+	full    bool
+}
+
+// reserve reports whether a file of n bytes still fits in dir, and counts it
+// if so.
+func (u *captureUsage) reserve(dir string, n int64, maxFiles int, maxBytes int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fits := func() bool { return u.files < maxFiles && u.bytes+n <= maxBytes }
+	if u.dir != dir || (!fits() && time.Since(u.scanned) >= rescanInterval) {
+		u.scan(dir)
+	}
+	if !fits() {
+		if !u.full {
+			u.full = true
+			log.Warn().Str("dir", dir).Int("files", u.files).Int64("bytes", u.bytes).
+				Msg("Capture directory is full, relayed messages are no longer stored")
+		}
+		return false
+	}
+	u.full = false
+	u.files++
+	u.bytes += n
+	return true
+}
+
+// scan counts the files in dir and their size.
+func (u *captureUsage) scan(dir string) {
+	u.dir, u.files, u.bytes, u.scanned = dir, 0, 0, time.Now()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			u.files++
+			u.bytes += info.Size()
+		}
+	}
+}
+
+// addressArg extracts the address from "FROM:<addr> SIZE=123" style arguments.
+func addressArg(arg string) string {
+	_, addr, ok := strings.Cut(arg, ":")
+	if !ok {
+		return strings.TrimSpace(arg)
+	}
+	addr = strings.TrimSpace(addr)
+	if i := strings.IndexByte(addr, '>'); i >= 0 {
+		addr = addr[:i+1]
+	}
+	return strings.Trim(addr, "<>")
+}
+
+var greetingWords = []string{
+	"ESMTP", "service", "ready", "please", "wait", "checking", "queue",
+	"relay", "access", "policy", "greylisting", "in", "progress", "spam",
+	"filter", "loading", "DNSBL", "lookup", "pending", "connection",
+}
+
+// randomWords builds a plausible looking filler line for the greeting.
+func randomWords() string {
+	count := rand.Intn(6) + 3
+	words := make([]string, count)
+	for i := range words {
+		words[i] = greetingWords[rand.Intn(len(greetingWords))]
+	}
+	return strings.Join(words, " ")
+}
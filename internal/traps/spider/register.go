@@ -0,0 +1,41 @@
+package spidertrap
+
+import (
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of a spider_trap trap instance.
+type Config struct {
+	trap.HTTPOptions `koanf:",squash"`
+	Corpus           string `koanf:"corpus"`
+}
+
+func init() {
+	trap.Register("spider_trap", trap.Factory{
+		Config: func() interface{} {
+			opts := trap.DefaultHTTPOptions()
+			opts.WriteTimeout = 10 * time.Second // We want to send the page quickly
+			return &Config{HTTPOptions: opts}
+		},
+		UsesCorpus: true,
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			h, err := deps.Corpus(c.Corpus)
+			if err != nil {
+				return nil, err
+			}
+			return New(addr, c.HTTPOptions, h, deps.Notifier, deps.Governor)
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			h, err := deps.Corpus(c.Corpus)
+			if err != nil {
+				return err
+			}
+			t.(*SpiderTrap).Reconfigure(c.HTTPOptions, h)
+			return nil
+		},
+	})
+}
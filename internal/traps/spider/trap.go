@@ -1,84 +1,55 @@
 package spidertrap
 
 import (
-	"context"
 	"fmt"
 	"math/rand"
 	"strings"
-	"time"
+	"sync/atomic"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
 	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
-	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
 	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
-	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
 )
 
 // SpiderTrap implements a recursive directory trap to confuse web crawlers.
 type SpiderTrap struct {
-	addr       string
-	serverName string
-	server     *fasthttp.Server
-	heffalump  *heffalump.Heffalump
-	notifier   *notifier.Notifier
+	*trap.HTTPServer
+	settings atomic.Pointer[settings]
 }
 
-// New creates a new instance of SpiderTrap.
-func New(addr, serverName string, h *heffalump.Heffalump, n *notifier.Notifier) *SpiderTrap {
-	return &SpiderTrap{
-		addr:       addr,
-		serverName: serverName,
-		heffalump:  h,
-		notifier:   n,
-	}
+// settings holds what can change on a config reload.
+type settings struct {
+	heffalump *heffalump.Heffalump
 }
 
-// Start starts the HTTP server.
-func (t *SpiderTrap) Start(ctx context.Context) error {
-	t.server = &fasthttp.Server{
-		Handler:      t.requestHandler,
-		Name:         t.serverName,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second, // We want to send the page quickly
-		IdleTimeout:  30 * time.Second,
-	}
-
-	log.Info().Str("address", t.addr).Msg("Starting Spider Trap")
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe(t.addr)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return t.Shutdown(context.Background())
-	case err := <-errChan:
-		return err
+// New creates a new instance of SpiderTrap.
+func New(addr string, opts trap.HTTPOptions, h *heffalump.Heffalump, n *notifier.Notifier, g *governor.Governor) (*SpiderTrap, error) {
+	t := &SpiderTrap{}
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "spider_trap",
+		Name:    "Spider Trap",
+		Alert:   true,
+		Handler: t.requestHandler,
+	}, n, g)
+	if err != nil {
+		return nil, err
 	}
+	t.HTTPServer = s
+	t.Reconfigure(opts, h)
+	return t, nil
 }
 
-// Shutdown gracefully shuts down the server.
-func (t *SpiderTrap) Shutdown(ctx context.Context) error {
-	log.Info().Msg("Shutting down Spider Trap")
-	if t.server != nil {
-		return t.server.Shutdown()
-	}
-	return nil
+// Reconfigure applies new settings to requests received from now on.
+func (t *SpiderTrap) Reconfigure(opts trap.HTTPOptions, h *heffalump.Heffalump) {
+	t.HTTPServer.Reconfigure(opts)
+	t.settings.Store(&settings{heffalump: h})
 }
 
 func (t *SpiderTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 	path := string(ctx.Path())
-	remoteIP := ctx.RemoteAddr().String()
-	userAgent := string(ctx.UserAgent())
-
-	log.Info().Str("path", path).Str("remote_addr", remoteIP).Msg("Spider Trap hit")
-	telemetry.TrapsTriggered.WithLabelValues("spider_trap", path).Inc()
-
-	// Send Alert
-	if t.notifier != nil {
-		t.notifier.SendAlert("SpiderTrap", remoteIP, userAgent)
-	}
+	cfg := t.settings.Load()
 
 	ctx.SetContentType("text/html")
 
@@ -92,12 +63,12 @@ func (t *SpiderTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 	// Generate 5-10 random subdirectories
 	count := rand.Intn(6) + 5 // 5 to 10
 
-	// Seed heffalump for this request
-	w1, w2 := t.heffalump.Seed()
+	// Each request walks the chain with its own generator
+	stream := cfg.heffalump.NewStream()
 
 	for i := 0; i < count; i++ {
 		// Get a random word for the directory name
-		word := t.heffalump.Next(w1, w2)
+		word := stream.Next()
 
 		// Clean up the word to make it URL-safe(ish) and look like a directory
 		cleanWord := strings.Map(func(r rune) rune {
@@ -117,10 +88,6 @@ func (t *SpiderTrap) requestHandler(ctx *fasthttp.RequestCtx) {
 		link := cleanWord + "/"
 
 		fmt.Fprintf(ctx, "<a href=\"%s\">%s</a>\n", link, link)
-
-		// Advance the chain
-		w3 := t.heffalump.Next(w1, w2)
-		w1, w2 = w2, w3
 	}
 
 	fmt.Fprintf(ctx, "</pre><hr></body></html>")
@@ -0,0 +1,30 @@
+package sshtrap
+
+import (
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// Config holds the options of an ssh_tarpit trap instance.
+type Config struct {
+	trap.ListenOptions `koanf:",squash"`
+	// Delay is the time between two banner lines.
+	Delay         time.Duration `koanf:"delay"`
+	MaxLineLength int           `koanf:"max_line_length"`
+}
+
+func init() {
+	trap.Register("ssh_tarpit", trap.Factory{
+		Config: func() interface{} { return &Config{} },
+		New: func(addr string, cfg interface{}, deps trap.Deps) (trap.Trap, error) {
+			c := cfg.(*Config)
+			return New(addr, c.ListenOptions, c.Delay, c.MaxLineLength, deps.Notifier, deps.Governor), nil
+		},
+		Reconfigure: func(t trap.Trap, cfg interface{}, deps trap.Deps) error {
+			c := cfg.(*Config)
+			t.(*SSHTarpit).Reconfigure(c.Delay, c.MaxLineLength)
+			return nil
+		},
+	})
+}
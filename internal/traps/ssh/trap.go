@@ -0,0 +1,235 @@
+package sshtrap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultDelay         = 10 * time.Second
+	defaultMaxLineLength = 32
+)
+
+// SSHTarpit implements the Trap interface for an endlessh-style SSH tarpit.
+// RFC 4253 (section 4.2) allows the server to send other lines of data before
+// the version string, so clients wait patiently while we drip random lines
+// at them and never send the "SSH-2.0-" banner.
+type SSHTarpit struct {
+	addr     string
+	listen   trap.ListenOptions
+	settings atomic.Pointer[settings]
+	notifier *notifier.Notifier
+	governor *governor.Governor
+	sessions *session.Group
+
+	mu       sync.Mutex
+	listener net.Listener
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// settings holds what can change on a config reload.
+type settings struct {
+	delay         time.Duration
+	maxLineLength int
+}
+
+// New creates a new instance of SSHTarpit.
+// A zero delay or maxLineLength falls back to sensible defaults.
+func New(addr string, listen trap.ListenOptions, delay time.Duration, maxLineLength int, n *notifier.Notifier, g *governor.Governor) *SSHTarpit {
+	t := &SSHTarpit{
+		addr:     addr,
+		listen:   listen,
+		notifier: n,
+		governor: g,
+		sessions: session.NewGroup("ssh_tarpit"),
+		quit:     make(chan struct{}),
+	}
+	t.Reconfigure(delay, maxLineLength)
+	return t
+}
+
+// Reconfigure applies new settings to connections accepted from now on.
+// Clients that are already trapped keep the settings they started with.
+func (t *SSHTarpit) Reconfigure(delay time.Duration, maxLineLength int) {
+	if delay <= 0 {
+		delay = defaultDelay
+	}
+	// Keep room for the trailing CRLF within the 255 byte limit of the RFC
+	if maxLineLength < 3 || maxLineLength > 253 {
+		maxLineLength = defaultMaxLineLength
+	}
+	t.settings.Store(&settings{delay: delay, maxLineLength: maxLineLength})
+}
+
+// Start starts the TCP listener and accepts connections until the context is cancelled.
+// Cancelling ctx only closes the listener; trapped clients are kept until Shutdown.
+func (t *SSHTarpit) Start(ctx context.Context) error {
+	ln, err := trap.Listen("tcp", t.addr, t.listen)
+	if err != nil {
+		return err
+	}
+	ln = t.governor.Listener(ln, "ssh_tarpit", nil)
+	ln = t.sessions.Listener(ln)
+	t.mu.Lock()
+	t.listener = ln
+	t.mu.Unlock()
+
+	log.Info().Str("address", t.addr).Msg("Starting SSH Tarpit")
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- t.serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		return <-errChan
+	case err := <-errChan:
+		return err
+	}
+}
+
+// Shutdown closes the listener and disconnects all trapped clients.
+func (t *SSHTarpit) Shutdown(ctx context.Context) error {
+	log.Info().Msg("Shutting down SSH Tarpit")
+	t.sessions.Shutdown()
+	t.quitOnce.Do(func() { close(t.quit) })
+
+	t.mu.Lock()
+	ln := t.listener
+	t.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// Active returns the number of trapped clients.
+func (t *SSHTarpit) Active() int {
+	return t.sessions.Active()
+}
+
+func (t *SSHTarpit) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.handleConn(conn)
+		}()
+	}
+}
+
+func (t *SSHTarpit) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	remoteIP := conn.RemoteAddr().String()
+	start := time.Now()
+
+	log.Info().Str("remote_addr", remoteIP).Msg("SSH Tarpit hit")
+	telemetry.TrapsTriggered.WithLabelValues("ssh_tarpit", "").Inc()
+	addr := proxy.AddrPort(conn.RemoteAddr())
+	store.Record(store.Event{Kind: store.KindHit, Trap: "ssh_tarpit", IP: addr.Addr().String()})
+
+	t.notifier.Send(notifier.Event{
+		Trap:      "ssh_tarpit",
+		Kind:      notifier.KindHit,
+		Severity:  notifier.SeverityWarning,
+		IP:        addr.Addr().String(),
+		Port:      int(addr.Port()),
+		SessionID: session.From(conn).ID(),
+	})
+
+	telemetry.ActiveConnections.Inc()
+	defer telemetry.ActiveConnections.Dec()
+
+	// Discard anything the client sends so its buffers never fill up
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	cfg := t.settings.Load()
+	ticker := time.NewTicker(cfg.delay)
+	defer ticker.Stop()
+
+	line := make([]byte, cfg.maxLineLength+2)
+	for {
+		select {
+		case <-t.quit:
+			return
+		case <-ticker.C:
+		}
+
+		n := randomLine(line, cfg.maxLineLength)
+		written, err := conn.Write(line[:n])
+		if err != nil {
+			log.Debug().
+				Str("remote_addr", remoteIP).
+				Dur("duration", time.Since(start)).
+				Msg("SSH Tarpit client disconnected")
+			return
+		}
+		telemetry.BytesSent.Add(float64(written))
+	}
+}
+
+// randomLine fills buf with a random printable line terminated by CRLF and
+// returns its length. The line never starts with "SSH-" so the client keeps waiting.
+func randomLine(buf []byte, maxLineLength int) int {
+	length := rand.Intn(maxLineLength-2) + 3
+	for i := 0; i < length; i++ {
+		buf[i] = byte(32 + rand.Intn(95))
+	}
+	if buf[0] == 'S' {
+		buf[0] = 's'
+	}
+	buf[length] = '\r'
+	buf[length+1] = '\n'
+	return length + 2
+}
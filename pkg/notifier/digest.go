@@ -0,0 +1,256 @@
+package notifier
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDigestTop = 5
+	// maxDigestKeys bounds the distinct IPs, paths and user agents counted
+	// per digest. Later ones are counted as digestOther.
+	maxDigestKeys = 10000
+	// maxDigestCredentials bounds the credentials listed in one digest.
+	maxDigestCredentials = 20
+	digestOther          = "(other)"
+)
+
+// DigestOptions configure periodic summaries of alerts.
+type DigestOptions struct {
+	// Interval between two digests. Zero disables digests, so every alert
+	// is sent on its own.
+	Interval time.Duration `koanf:"interval"`
+	// ImmediateSeverity is the lowest severity still sent on its own right
+	// away, e.g. captured credentials. Empty means critical.
+	ImmediateSeverity string `koanf:"immediate_severity"`
+	// Top is the number of IPs, paths and user agents listed. Zero means 5.
+	Top int `koanf:"top"`
+}
+
+// Validate checks the severity of the options.
+func (o DigestOptions) Validate() error {
+	if _, err := o.immediate(); err != nil {
+		return err
+	}
+	if o.Interval < 0 || o.Top < 0 {
+		return fmt.Errorf("interval and top must not be negative")
+	}
+	return nil
+}
+
+func (o DigestOptions) immediate() (Severity, error) {
+	if o.ImmediateSeverity == "" {
+		return SeverityCritical, nil
+	}
+	s, err := ParseSeverity(o.ImmediateSeverity)
+	if err != nil {
+		return 0, fmt.Errorf("immediate_severity: %w", err)
+	}
+	return s, nil
+}
+
+// Digest summarizes the alerts of one interval.
+type Digest struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Events is the number of alerts, including those sent right away.
+	Events int `json:"events"`
+	// Traps counts the alerts by trap type.
+	Traps         []Count `json:"traps"`
+	TopIPs        []Count `json:"top_ips"`
+	TopPaths      []Count `json:"top_paths,omitempty"`
+	TopUserAgents []Count `json:"top_user_agents,omitempty"`
+	// Credentials lists the distinct credentials captured, up to 20.
+	Credentials []CapturedCredentials `json:"credentials,omitempty"`
+	// BytesWasted is the data sent to the clients whose connections ended.
+	BytesWasted int64 `json:"bytes_wasted"`
+}
+
+// Count is the number of alerts sharing a value.
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// CapturedCredentials are credentials listed in a digest.
+type CapturedCredentials struct {
+	Credentials
+	Trap string    `json:"trap"`
+	IP   string    `json:"ip"`
+	Time time.Time `json:"time"`
+}
+
+// Title is the headline of the digest.
+func (d Digest) Title() string {
+	return fmt.Sprintf("VoidSink Digest: %d alerts in %s", d.Events, d.End.Sub(d.Start).Round(time.Second))
+}
+
+// Summary is a one-line overview of the digest.
+func (d Digest) Summary() string {
+	s := fmt.Sprintf("%d alerts from %d traps", d.Events, len(d.Traps))
+	if len(d.Credentials) > 0 {
+		s += fmt.Sprintf(", %d credentials captured", len(d.Credentials))
+	}
+	if d.BytesWasted > 0 {
+		s += ", " + formatBytes(d.BytesWasted) + " wasted"
+	}
+	return s
+}
+
+// DigestSection is a titled list in a digest.
+type DigestSection struct {
+	Title string
+	// Rows name an attacker-controlled value and describe it.
+	Rows []Detail
+}
+
+// Sections lists the parts of the digest that are not empty, in display order.
+func (d Digest) Sections() []DigestSection {
+	var sections []DigestSection
+	add := func(title string, counts []Count) {
+		if len(counts) == 0 {
+			return
+		}
+		rows := make([]Detail, len(counts))
+		for i, c := range counts {
+			rows[i] = Detail{Name: c.Value, Value: strconv.Itoa(c.Count)}
+		}
+		sections = append(sections, DigestSection{Title: title, Rows: rows})
+	}
+
+	add("Traps", d.Traps)
+	add("Top IPs", d.TopIPs)
+	add("Top Paths", d.TopPaths)
+	add("Top User Agents", d.TopUserAgents)
+	if len(d.Credentials) > 0 {
+		rows := make([]Detail, len(d.Credentials))
+		for i, c := range d.Credentials {
+			rows[i] = Detail{Name: c.Username + " / " + c.Password, Value: c.Trap + " from " + c.IP}
+		}
+		sections = append(sections, DigestSection{Title: "Credentials", Rows: rows})
+	}
+	return sections
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// digester collects the alerts of one route until the next digest.
+type digester struct {
+	top int
+
+	mu          sync.Mutex
+	start       time.Time
+	events      int
+	traps       map[string]int
+	ips         map[string]int
+	paths       map[string]int
+	agents      map[string]int
+	credentials []CapturedCredentials
+	seen        map[Credentials]bool
+	bytes       int64
+}
+
+func newDigester(top int, now time.Time) *digester {
+	g := &digester{top: top}
+	g.reset(now)
+	return g
+}
+
+func (g *digester) reset(now time.Time) {
+	g.start = now
+	g.events = 0
+	g.traps = make(map[string]int)
+	g.ips = make(map[string]int)
+	g.paths = make(map[string]int)
+	g.agents = make(map[string]int)
+	g.credentials = nil
+	g.seen = make(map[Credentials]bool)
+	g.bytes = 0
+}
+
+func (g *digester) add(e Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.events++
+	g.traps[e.Trap]++
+	countKey(g.ips, e.IP)
+	countKey(g.paths, e.Path)
+	countKey(g.agents, e.UserAgent)
+	if c := e.Credentials; c != nil && !g.seen[*c] && len(g.credentials) < maxDigestCredentials {
+		g.seen[*c] = true
+		g.credentials = append(g.credentials, CapturedCredentials{Credentials: *c, Trap: e.Trap, IP: e.IP, Time: e.Time})
+	}
+}
+
+// countKey counts k in m, lumping new keys together once m is full.
+func countKey(m map[string]int, k string) {
+	if k == "" {
+		return
+	}
+	if _, ok := m[k]; !ok && len(m) >= maxDigestKeys {
+		k = digestOther
+	}
+	m[k]++
+}
+
+func (g *digester) addBytes(n int64) {
+	g.mu.Lock()
+	g.bytes += n
+	g.mu.Unlock()
+}
+
+// take returns the digest collected until now and starts the next one. It
+// returns nil if nothing happened.
+func (g *digester) take(now time.Time) *Digest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.events == 0 && g.bytes == 0 {
+		g.start = now
+		return nil
+	}
+	d := &Digest{
+		Start:         g.start,
+		End:           now,
+		Events:        g.events,
+		Traps:         topCounts(g.traps, 0),
+		TopIPs:        topCounts(g.ips, g.top),
+		TopPaths:      topCounts(g.paths, g.top),
+		TopUserAgents: topCounts(g.agents, g.top),
+		Credentials:   g.credentials,
+		BytesWasted:   g.bytes,
+	}
+	g.reset(now)
+	return d
+}
+
+// topCounts returns the n most frequent values of m, or all of them if n is 0.
+func topCounts(m map[string]int, n int) []Count {
+	counts := make([]Count, 0, len(m))
+	for v, c := range m {
+		counts = append(counts, Count{Value: v, Count: c})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
@@ -0,0 +1,121 @@
+package notifier
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of events.
+const (
+	// KindHit is a client reaching a trap.
+	KindHit = "hit"
+	// KindCredential is a login attempt captured by a trap.
+	KindCredential = "credential"
+	// KindDigest marks digests posted by the generic webhook sink.
+	KindDigest = "digest"
+)
+
+// Event is an alert raised by a trap. Sinks render every field that is set,
+// and the generic webhook sink posts it as JSON.
+type Event struct {
+	// Trap is the trap type, e.g. "login_trap".
+	Trap     string    `json:"trap"`
+	Kind     string    `json:"kind"`
+	Severity Severity  `json:"severity"`
+	Time     time.Time `json:"time"`
+
+	// IP is the client address, resolved through trusted proxies.
+	IP string `json:"ip"`
+	// Port is the client port, when known.
+	Port      int    `json:"port,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+
+	Fingerprint Fingerprint  `json:"fingerprint,omitzero"`
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// SessionID is the ID of the connection in the session log and admin API.
+	SessionID uint64 `json:"session_id,omitempty"`
+	// Extra holds fields specific to a trap.
+	Extra map[string]string `json:"extra,omitempty"`
+
+	// Suppressed is the number of alerts about the same client held back by
+	// the rate limit since the previous one was sent.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Fingerprint identifies the client software.
+type Fingerprint struct {
+	JA3  string `json:"ja3,omitempty"`
+	JA4  string `json:"ja4,omitempty"`
+	JA4H string `json:"ja4h,omitempty"`
+	// Class is the kind of client, e.g. "scanner" or "fake_browser".
+	Class string `json:"class,omitempty"`
+}
+
+// Credentials are a captured username and password.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Title is the headline of the event.
+func (e Event) Title() string {
+	switch e.Kind {
+	case KindCredential:
+		return "Credentials Captured: " + e.Trap
+	case KindHit:
+		return "Trap Triggered: " + e.Trap
+	}
+	return e.Kind + ": " + e.Trap
+}
+
+// Detail is a named value shown in an alert.
+type Detail struct {
+	Name  string
+	Value string
+}
+
+// Fields lists the fields of the event that are set, in display order.
+func (e Event) Fields() []Detail {
+	var fields []Detail
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, Detail{Name: name, Value: value})
+		}
+	}
+
+	add("IP", e.IP)
+	if e.Port != 0 {
+		add("Port", strconv.Itoa(e.Port))
+	}
+	if e.Credentials != nil {
+		add("Username", e.Credentials.Username)
+		add("Password", e.Credentials.Password)
+	}
+	if e.Method != "" || e.Path != "" {
+		add("Request", e.Method+" "+e.Path)
+	}
+	add("UA", e.UserAgent)
+	add("Class", e.Fingerprint.Class)
+	add("JA4H", e.Fingerprint.JA4H)
+	add("JA3", e.Fingerprint.JA3)
+	add("JA4", e.Fingerprint.JA4)
+	if e.SessionID != 0 {
+		add("Session", strconv.FormatUint(e.SessionID, 10))
+	}
+	if e.Suppressed != 0 {
+		add("Suppressed", strconv.Itoa(e.Suppressed)+" alerts")
+	}
+
+	keys := make([]string, 0, len(e.Extra))
+	for k := range e.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, e.Extra[k])
+	}
+	return fields
+}
@@ -0,0 +1,328 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Size limits of the chat sinks, in characters. They reject a message that
+// exceeds any of them, so attacker-controlled values are cut to fit.
+const (
+	// legacyLimit is the length of a Discord message.
+	legacyLimit = 2000
+	// slackFieldLimit is the length of the text of a Slack section field.
+	slackFieldLimit = 2000
+	// discordFieldLimit is the length of a Discord embed field value, and
+	// discordEmbedLimit the length of all the text of an embed.
+	discordFieldLimit = 1024
+	discordEmbedLimit = 6000
+	// teamsFactLimit keeps an Adaptive Card well below the 28 KB Teams accepts.
+	teamsFactLimit = 1000
+)
+
+// Colors of Discord embeds and Teams accents by severity.
+var severityColors = map[Severity]int{
+	SeverityInfo:     0x3498db,
+	SeverityWarning:  0xf39c12,
+	SeverityCritical: 0xe74c3c,
+}
+
+// code quotes an attacker-controlled value as inline code. Backticks are
+// replaced so the value can't break out of the code span.
+func code(v string) string {
+	return "`" + strings.ReplaceAll(v, "`", "'") + "`"
+}
+
+// clip returns render(v), with v cut short and marked with "…" if needed so
+// the result is at most limit characters long.
+func clip(v string, limit int, render func(string) string) string {
+	if out := render(v); utf8.RuneCountInString(out) <= limit {
+		return out
+	}
+	// Find the longest prefix that still fits once rendered
+	runes := []rune(v)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if utf8.RuneCountInString(render(string(runes[:mid])+"…")) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return render(string(runes[:lo]) + "…")
+}
+
+// share divides a budget of total characters between n values, giving each
+// at most limit.
+func share(total, n, limit int) int {
+	if n == 0 {
+		return limit
+	}
+	return min(limit, total/n)
+}
+
+// slackEscape escapes the characters Slack treats as control sequences.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// legacyPayload is the message sent to webhook_url before sinks existed.
+func legacyPayload(e Event) interface{} {
+	msg := fmt.Sprintf("🚨 **%s**", e.Title())
+	fields := e.Fields()
+	// Leave room for the title and the field names
+	limit := share(legacyLimit-400, len(fields), legacyLimit)
+	for _, f := range fields {
+		msg += fmt.Sprintf("\n**%s:** %s", f.Name, clip(f.Value, limit, code))
+	}
+	if utf8.RuneCountInString(msg) > legacyLimit {
+		msg = string([]rune(msg)[:legacyLimit-1]) + "…"
+	}
+	return map[string]string{
+		"content": msg, // Discord
+		"text":    msg, // Slack
+	}
+}
+
+// slackPayload builds a Block Kit message. text is the fallback shown in
+// notifications.
+func slackPayload(e Event) interface{} {
+	fields := make([]map[string]string, 0, len(e.Fields()))
+	slackCode := func(v string) string { return slackEscape.Replace(code(v)) }
+	for _, f := range e.Fields() {
+		name := fmt.Sprintf("*%s*\n", f.Name)
+		fields = append(fields, map[string]string{
+			"type": "mrkdwn",
+			"text": name + clip(f.Value, slackFieldLimit-utf8.RuneCountInString(name), slackCode),
+		})
+	}
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": "🚨 " + e.Title()},
+		},
+	}
+	// Slack allows at most 10 fields per section
+	for len(fields) > 0 {
+		n := min(len(fields), 10)
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields[:n]})
+		fields = fields[n:]
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]string{{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("Severity: *%s* | %s", e.Severity, e.Time.UTC().Format(time.RFC3339)),
+		}},
+	})
+	return map[string]interface{}{
+		"text":   slackEscape.Replace(fmt.Sprintf("🚨 %s from %s", e.Title(), e.IP)),
+		"blocks": blocks,
+	}
+}
+
+// discordPayload builds a message with one embed.
+func discordPayload(e Event) interface{} {
+	details := e.Fields()
+	fields := make([]map[string]interface{}, 0, len(details))
+	// Leave room for the title, footer and field names
+	limit := share(discordEmbedLimit-1000, len(details), discordFieldLimit)
+	for _, f := range details {
+		fields = append(fields, map[string]interface{}{
+			"name":   f.Name,
+			"value":  clip(f.Value, limit, code),
+			"inline": len(f.Value) <= 40,
+		})
+	}
+	return map[string]interface{}{
+		"embeds": []map[string]interface{}{{
+			"title":     "🚨 " + e.Title(),
+			"color":     severityColors[e.Severity],
+			"fields":    fields,
+			"timestamp": e.Time.UTC().Format(time.RFC3339),
+			"footer":    map[string]string{"text": "VoidSink | " + e.Severity.String()},
+		}},
+	}
+}
+
+// teamsPayload builds an Adaptive Card, as accepted by Teams workflow webhooks.
+func teamsPayload(e Event) interface{} {
+	facts := make([]map[string]string, 0, len(e.Fields()))
+	for _, f := range e.Fields() {
+		facts = append(facts, map[string]string{"title": f.Name, "value": clip(f.Value, teamsFactLimit, noEscape)})
+	}
+	color := "Warning"
+	switch e.Severity {
+	case SeverityInfo:
+		color = "Accent"
+	case SeverityCritical:
+		color = "Attention"
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					{"type": "TextBlock", "text": "🚨 " + e.Title(), "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+					{"type": "FactSet", "facts": facts},
+					{"type": "TextBlock", "text": e.Severity.String() + " | " + e.Time.UTC().Format(time.RFC3339), "isSubtle": true, "size": "Small"},
+				},
+			},
+		}},
+	}
+}
+
+// jsonPayload posts the event itself, for consumers that parse alerts.
+func jsonPayload(e Event) interface{} {
+	e.Time = e.Time.UTC()
+	return e
+}
+
+// digestLines renders rows as lines of inline code followed by their
+// description, cut to at most limit characters. escape is applied to each
+// line. A first row too long on its own is clipped, so the section is never
+// reduced to a bare "…".
+func digestLines(rows []Detail, limit int, escape func(string) string) string {
+	var b strings.Builder
+	for i, r := range rows {
+		line := escape(code(r.Name) + " " + r.Value)
+		if b.Len()+len(line)+len("\n…") > limit {
+			if i == 0 {
+				line = clip(r.Name, limit-len("\n…"), func(name string) string {
+					return escape(code(name) + " " + r.Value)
+				})
+			} else {
+				b.WriteString("\n…")
+				break
+			}
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+func noEscape(s string) string {
+	return s
+}
+
+// legacyDigest is the digest sent to webhook_url. Discord caps the message
+// at 2000 characters, hence the short sections.
+func legacyDigest(d Digest) interface{} {
+	msg := fmt.Sprintf("📊 **%s**\n%s", d.Title(), d.Summary())
+	for _, s := range d.Sections() {
+		msg += fmt.Sprintf("\n**%s**\n%s", s.Title, digestLines(s.Rows, 250, noEscape))
+	}
+	return map[string]string{
+		"content": msg, // Discord
+		"text":    msg, // Slack
+	}
+}
+
+// slackDigest builds a Block Kit message with a section per list.
+func slackDigest(d Digest) interface{} {
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": "📊 " + d.Title()},
+		},
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": d.Summary()},
+		},
+	}
+	for _, s := range d.Sections() {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				// Slack allows 3000 characters per text
+				"text": fmt.Sprintf("*%s*\n%s", s.Title, digestLines(s.Rows, 2900, slackEscape.Replace)),
+			},
+		})
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]string{{
+			"type": "mrkdwn",
+			"text": d.Start.UTC().Format(time.RFC3339) + " to " + d.End.UTC().Format(time.RFC3339),
+		}},
+	})
+	return map[string]interface{}{
+		"text":   "📊 " + d.Title(),
+		"blocks": blocks,
+	}
+}
+
+// discordDigest builds a message with one embed and a field per list.
+func discordDigest(d Digest) interface{} {
+	fields := make([]map[string]interface{}, 0, len(d.Sections()))
+	for _, s := range d.Sections() {
+		fields = append(fields, map[string]interface{}{
+			"name": s.Title,
+			// Discord allows 1024 characters per field value
+			"value":  digestLines(s.Rows, 1000, noEscape),
+			"inline": false,
+		})
+	}
+	return map[string]interface{}{
+		"embeds": []map[string]interface{}{{
+			"title":       "📊 " + d.Title(),
+			"description": d.Summary(),
+			"color":       severityColors[SeverityInfo],
+			"fields":      fields,
+			"timestamp":   d.End.UTC().Format(time.RFC3339),
+			"footer":      map[string]string{"text": "VoidSink | digest"},
+		}},
+	}
+}
+
+// teamsDigest builds an Adaptive Card with a fact set per list.
+func teamsDigest(d Digest) interface{} {
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": "📊 " + d.Title(), "weight": "Bolder", "size": "Medium", "wrap": true},
+		{"type": "TextBlock", "text": d.Summary(), "wrap": true},
+	}
+	for _, s := range d.Sections() {
+		facts := make([]map[string]string, 0, len(s.Rows))
+		for _, r := range s.Rows {
+			facts = append(facts, map[string]string{"title": clip(r.Name, teamsFactLimit, noEscape), "value": r.Value})
+		}
+		body = append(body,
+			map[string]interface{}{"type": "TextBlock", "text": s.Title, "weight": "Bolder", "wrap": true},
+			map[string]interface{}{"type": "FactSet", "facts": facts},
+		)
+	}
+	body = append(body, map[string]interface{}{
+		"type": "TextBlock", "text": d.Start.UTC().Format(time.RFC3339) + " to " + d.End.UTC().Format(time.RFC3339), "isSubtle": true, "size": "Small",
+	})
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// jsonDigest posts the digest itself, marked with kind "digest" to tell it
+// apart from events.
+func jsonDigest(d Digest) interface{} {
+	d.Start, d.End = d.Start.UTC(), d.End.UTC()
+	return struct {
+		Kind string `json:"kind"`
+		Digest
+	}{KindDigest, d}
+}
@@ -1,81 +1,372 @@
 package notifier
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-// Notifier handles sending alerts to external services (Discord/Slack).
+// sendTimeout limits delivering one alert to one sink.
+const sendTimeout = 5 * time.Second
+
+// Defaults of Options.
+const (
+	defaultQueueSize   = 1000
+	defaultWorkers     = 4
+	defaultMaxAttempts = 8
+	defaultBackoff     = time.Second
+	defaultSpoolRetry  = 5 * time.Minute
+)
+
+// Options configure where alerts are sent.
+type Options struct {
+	// WebhookURL is a single Discord or Slack webhook. Kept for older
+	// configurations; Sinks can do the same and more.
+	WebhookURL string `koanf:"webhook_url"`
+	// Sinks are the destinations of alerts. Every matching sink gets a copy.
+	Sinks []SinkConfig `koanf:"sinks"`
+
+	// QueueSize is the number of deliveries waiting for a worker before new
+	// ones are spooled. Zero means 1000.
+	QueueSize int `koanf:"queue_size"`
+	// Workers deliver alerts concurrently. Zero means 4.
+	Workers int `koanf:"workers"`
+	// MaxAttempts is how often a delivery is tried before it is given up on
+	// and spooled. Zero means 8.
+	MaxAttempts int `koanf:"max_attempts"`
+	// Backoff is the delay before the first retry. It doubles with every
+	// attempt, up to five minutes. Zero means one second.
+	Backoff time.Duration `koanf:"backoff"`
+	// SpoolPath is a file keeping the alerts that could not be delivered,
+	// whether given up on or still queued at shutdown. They are sent again
+	// every SpoolRetry and at the next start. Empty drops them.
+	SpoolPath string `koanf:"spool_path"`
+	// SpoolRetry is the interval between two attempts to send the spooled
+	// alerts while running. Zero means five minutes.
+	SpoolRetry time.Duration `koanf:"spool_retry"`
+
+	// RateLimit suppresses repeated alerts about the same client.
+	RateLimit RateLimitOptions `koanf:"rate_limit"`
+	// Digest batches alerts into periodic summaries.
+	Digest DigestOptions `koanf:"digest"`
+}
+
+// Validate checks every sink and the rate limit of the options.
+func (o Options) Validate() error {
+	if _, err := o.routes(http.DefaultClient); err != nil {
+		return err
+	}
+	if err := o.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("rate_limit.%w", err)
+	}
+	if err := o.Digest.Validate(); err != nil {
+		return fmt.Errorf("digest.%w", err)
+	}
+	return nil
+}
+
+func (o Options) routes(client *http.Client) ([]route, error) {
+	var routes []route
+	if o.WebhookURL != "" {
+		routes = append(routes, route{id: routeID("webhook_url", o.WebhookURL), sink: legacySink(o.WebhookURL, client)})
+	}
+	for i, c := range o.Sinks {
+		r, err := c.route(client)
+		if err != nil {
+			return nil, fmt.Errorf("sinks[%d]: %w", i, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// Notifier handles sending alerts to external services (Slack, Discord, Teams, webhooks).
+//
+// Alerts are queued per sink and delivered by a pool of workers, so a slow or
+// failing webhook never holds up a trap. Failed deliveries are retried with
+// exponential backoff.
 type Notifier struct {
-	webhookURL string
-	client     *http.Client
-	// rateLimitCache stores the last alert time for an IP to prevent spam.
-	rateLimitCache    sync.Map
-	rateLimitDuration time.Duration
+	routes []route
+	opts   Options
+	queue  chan *delivery
+	// ctx is canceled by Close, aborting deliveries in flight.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	// mu guards closed, the deliveries waiting for a retry and the sinks
+	// blocked by a Retry-After.
+	mu       sync.Mutex
+	closed   bool
+	retrying map[*delivery]*time.Timer
+	blocked  map[*route]time.Time
+	// spoolMu serializes writes to the spool file.
+	spoolMu sync.Mutex
+	// Summaries of the alerts that could not be queued, so an outage
+	// doesn't log every alert.
+	queueFull, dropped, spooled summary
+
+	// limiter suppresses repeated alerts about the same client.
+	limiter *limiter
+	// immediate is the lowest severity sent right away in digest mode.
+	immediate Severity
 }
 
-// New creates a new Notifier instance.
-func New(webhookURL string) *Notifier {
-	return &Notifier{
-		webhookURL:        webhookURL,
-		client:            &http.Client{Timeout: 5 * time.Second},
-		rateLimitDuration: 1 * time.Hour,
+// New creates a new Notifier instance and starts its workers. It fails if a
+// sink is misconfigured. Alerts left in the spool file are queued again.
+func New(opts Options) (*Notifier, error) {
+	routes, err := opts.routes(&http.Client{Timeout: sendTimeout})
+	if err != nil {
+		return nil, err
 	}
+	limiter, err := newLimiter(opts.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("rate_limit.%w", err)
+	}
+	if err := opts.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("digest.%w", err)
+	}
+	immediate, _ := opts.Digest.immediate()
+	if opts.Digest.Top <= 0 {
+		opts.Digest.Top = defaultDigestTop
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.SpoolRetry <= 0 {
+		opts.SpoolRetry = defaultSpoolRetry
+	}
+
+	n := &Notifier{
+		routes:    routes,
+		opts:      opts,
+		queue:     make(chan *delivery, opts.QueueSize),
+		retrying:  make(map[*delivery]*time.Timer),
+		blocked:   make(map[*route]time.Time),
+		queueFull: summary{level: zerolog.WarnLevel, msg: "Alert queue full, spooling alerts"},
+		dropped:   summary{level: zerolog.WarnLevel, msg: "Dropping undelivered alerts, set notification.spool_path to keep them"},
+		spooled:   summary{level: zerolog.InfoLevel, msg: "Spooled undelivered alerts"},
+		limiter:   limiter,
+		immediate: immediate,
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	if len(routes) == 0 {
+		return n, nil
+	}
+	for range opts.Workers {
+		n.workers.Add(1)
+		go n.work()
+	}
+	if opts.Digest.Interval > 0 {
+		now := time.Now()
+		for i := range n.routes {
+			if _, ok := n.routes[i].sink.(DigestSink); ok {
+				n.routes[i].digest = newDigester(opts.Digest.Top, now)
+			}
+		}
+		n.workers.Add(1)
+		go n.digests()
+	}
+	if err := n.loadSpool(); err != nil {
+		log.Error().Err(err).Str("path", opts.SpoolPath).Msg("Failed to read the alert spool")
+	}
+	if opts.SpoolPath != "" {
+		n.workers.Add(1)
+		go n.replaySpool()
+	}
+	return n, nil
 }
 
-// SendAlert sends a notification to the configured webhook.
-// It includes rate limiting logic to avoid spamming for the same IP.
-func (n *Notifier) SendAlert(trapName, remoteIP, userAgent string) {
-	if n.webhookURL == "" {
+// Send queues e for every sink whose filter matches. A nil Notifier sends
+// nothing. Alerts about a client already alerted on within the rate limit
+// window are suppressed, and counted in the next one that goes out.
+//
+// In digest mode, e is also counted in the next digest of each sink, and
+// only sent right away if it is at least as severe as the digest's
+// immediate severity.
+func (n *Notifier) Send(e Event) {
+	if n == nil || len(n.routes) == 0 {
 		return
 	}
 
-	// Rate Limiting: Check if we've seen this IP recently
-	if lastSeen, ok := n.rateLimitCache.Load(remoteIP); ok {
-		if time.Since(lastSeen.(time.Time)) < n.rateLimitDuration {
-			return // Skip alert
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var routes []*route
+	for i := range n.routes {
+		r := &n.routes[i]
+		if !r.filter.match(e) {
+			continue
+		}
+		if r.digest != nil {
+			r.digest.add(e)
+			if e.Severity < n.immediate {
+				continue
+			}
 		}
+		routes = append(routes, r)
 	}
-	// Update last seen time
-	n.rateLimitCache.Store(remoteIP, time.Now())
+	if len(routes) == 0 {
+		return
+	}
+
+	ok, suppressed := n.limiter.allow(e, time.Now())
+	if !ok {
+		telemetry.NotifierSuppressed.Inc()
+		return
+	}
+	e.Suppressed = suppressed
+	for _, r := range routes {
+		n.enqueue(&delivery{Sink: r.id, Event: e, route: r})
+	}
+}
 
-	// Run async to not block the trap handler
-	go n.send(trapName, remoteIP, userAgent)
+// CountWasted adds bytes sent to a client of the trap to the next digests.
+// It does nothing outside digest mode.
+func (n *Notifier) CountWasted(trap string, bytes int64) {
+	if n == nil {
+		return
+	}
+	for i := range n.routes {
+		if r := &n.routes[i]; r.digest != nil && r.filter.matchTrap(trap) {
+			r.digest.addBytes(bytes)
+		}
+	}
 }
 
-func (n *Notifier) send(trapName, remoteIP, userAgent string) {
-	// Construct payload compatible with Discord (content) and Slack (text)
-	// We'll use a generic map to send both fields if needed, or just "content" for Discord.
-	// For this implementation, we target Discord's format.
-	msg := fmt.Sprintf("🚨 **Trap Triggered!**\n**Trap:** `%s`\n**IP:** `%s`\n**UA:** `%s`", trapName, remoteIP, userAgent)
+// digests queues the digest of every sink at each interval.
+func (n *Notifier) digests() {
+	defer n.workers.Done()
+	ticker := time.NewTicker(n.opts.Digest.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, d := range n.takeDigests(now) {
+				n.enqueue(d)
+			}
+		}
+	}
+}
 
-	payload := map[string]string{
-		"content": msg, // Discord
-		"text":    msg, // Slack
+// takeDigests returns the deliveries of the digests collected until now.
+func (n *Notifier) takeDigests(now time.Time) []*delivery {
+	var ds []*delivery
+	for i := range n.routes {
+		r := &n.routes[i]
+		if r.digest == nil {
+			continue
+		}
+		if d := r.digest.take(now); d != nil {
+			ds = append(ds, &delivery{Sink: r.id, Digest: d, route: r})
+		}
 	}
+	return ds
+}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal alert payload")
+// Close stops delivering alerts. Deliveries still queued, waiting for a
+// retry or in flight, and digests not sent yet are written to the spool
+// file. A nil Notifier ignores Close.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
 		return
 	}
+	n.closed = true
+	var pending []*delivery
+	for d, t := range n.retrying {
+		// A timer that already fired queues its delivery, which spools it now
+		if t.Stop() {
+			pending = append(pending, d)
+		}
+	}
+	n.retrying = nil
+	n.mu.Unlock()
+
+	n.cancel()
+	n.workers.Wait()
+	for {
+		select {
+		case d := <-n.queue:
+			telemetry.NotifierQueueDepth.Dec()
+			pending = append(pending, d)
+			continue
+		default:
+		}
+		break
+	}
+	pending = append(pending, n.takeDigests(time.Now())...)
+	n.spool(pending...)
+	n.queueFull.flush()
+	n.dropped.flush()
+	n.spooled.flush()
+}
+
+// Severity ranks alerts so sinks can ignore the less important ones.
+type Severity int
+
+// Severities from least to most important.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+var severityNames = []string{"info", "warning", "critical"}
 
-	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewBuffer(data))
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes the severity by name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name.
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to send webhook alert")
-		return
+		return err
 	}
-	defer resp.Body.Close()
+	*s = v
+	return nil
+}
 
-	if resp.StatusCode >= 400 {
-		log.Error().Int("status", resp.StatusCode).Msg("Webhook returned error status")
-	} else {
-		log.Debug().Str("trap", trapName).Msg("Alert sent successfully")
+// ParseSeverity parses a severity name. An empty name is SeverityInfo.
+func ParseSeverity(name string) (Severity, error) {
+	if name == "" {
+		return SeverityInfo, nil
+	}
+	for i, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return Severity(i), nil
+		}
 	}
+	return 0, fmt.Errorf("unknown severity %q (known: %s)", name, strings.Join(severityNames, ", "))
 }
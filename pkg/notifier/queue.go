@@ -0,0 +1,378 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxBackoff caps the delay between two attempts.
+	maxBackoff = 5 * time.Minute
+	// spoolMaxAge drops spooled alerts too old to be worth sending.
+	spoolMaxAge = 24 * time.Hour
+	// summaryInterval is the minimum time between two logs of a summary.
+	summaryInterval = time.Minute
+)
+
+// summary logs a message about alerts at most once per summaryInterval,
+// with the number of alerts since the last log.
+type summary struct {
+	level zerolog.Level
+	msg   string
+
+	mu     sync.Mutex
+	next   time.Time
+	alerts int
+}
+
+// add counts n alerts and logs them unless the last log is too recent.
+func (s *summary) add(n int) {
+	s.mu.Lock()
+	s.alerts += n
+	now := time.Now()
+	if now.Before(s.next) {
+		s.mu.Unlock()
+		return
+	}
+	alerts := s.alerts
+	s.alerts, s.next = 0, now.Add(summaryInterval)
+	s.mu.Unlock()
+	log.WithLevel(s.level).Int("alerts", alerts).Msg(s.msg)
+}
+
+// flush logs the alerts counted since the last log, if any.
+func (s *summary) flush() {
+	s.mu.Lock()
+	alerts := s.alerts
+	s.alerts = 0
+	s.mu.Unlock()
+	if alerts > 0 {
+		log.WithLevel(s.level).Int("alerts", alerts).Msg(s.msg)
+	}
+}
+
+// delivery is an alert or a digest on its way to one sink. It is stored as
+// a line of JSON in the spool file.
+type delivery struct {
+	// Sink is the ID of the route, so a spooled delivery finds its sink again
+	// after a restart.
+	Sink string `json:"sink"`
+	// Event is the alert, unless Digest is set.
+	Event    Event   `json:"event,omitzero"`
+	Digest   *Digest `json:"digest,omitempty"`
+	Attempts int     `json:"attempts"`
+
+	route *route
+}
+
+// kind returns the kind of the alert, or KindDigest.
+func (d *delivery) kind() string {
+	if d.Digest != nil {
+		return KindDigest
+	}
+	return d.Event.Kind
+}
+
+// time returns when the alert happened or the digest ended.
+func (d *delivery) time() time.Time {
+	if d.Digest != nil {
+		return d.Digest.End
+	}
+	return d.Event.Time
+}
+
+func (d *delivery) send(ctx context.Context) error {
+	if d.Digest != nil {
+		// Digests are only collected for DigestSinks
+		return d.route.sink.(DigestSink).SendDigest(ctx, *d.Digest)
+	}
+	return d.route.sink.Send(ctx, d.Event)
+}
+
+// enqueue hands d to the workers, or spools it if the queue is full or the
+// notifier is closed.
+func (n *Notifier) enqueue(d *delivery) {
+	n.mu.Lock()
+	closed := n.closed
+	queued := false
+	if !closed {
+		select {
+		case n.queue <- d:
+			telemetry.NotifierQueueDepth.Inc()
+			queued = true
+		default:
+		}
+	}
+	n.mu.Unlock()
+
+	if queued {
+		return
+	}
+	if !closed {
+		n.queueFull.add(1)
+	}
+	n.spool(d)
+}
+
+func (n *Notifier) work() {
+	defer n.workers.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case d := <-n.queue:
+			telemetry.NotifierQueueDepth.Dec()
+			n.deliver(d)
+		}
+	}
+}
+
+// deliver sends d once and schedules a retry if that fails. While the sink
+// is blocked by a Retry-After, d is held back until the sink accepts alerts
+// again, without using up an attempt.
+func (n *Notifier) deliver(d *delivery) {
+	if wait := n.blockedFor(d.route); wait > 0 {
+		n.retry(d, wait)
+		return
+	}
+	sink := d.route.sink
+	ctx, cancel := context.WithTimeout(n.ctx, sendTimeout)
+	err := d.send(ctx)
+	cancel()
+	if err == nil {
+		telemetry.NotifierDelivered.WithLabelValues(sink.Name()).Inc()
+		log.Debug().Str("sink", sink.Name()).Str("trap", d.Event.Trap).Str("kind", d.kind()).Msg("Alert sent successfully")
+		return
+	}
+	if n.ctx.Err() != nil {
+		// Aborted by Close
+		n.spool(d)
+		return
+	}
+
+	d.Attempts++
+	telemetry.NotifierFailures.WithLabelValues(sink.Name()).Inc()
+	logger := log.With().Err(err).Str("sink", sink.Name()).Str("trap", d.Event.Trap).Int("attempts", d.Attempts).Logger()
+
+	var status *StatusError
+	if errors.As(err, &status) && !status.Temporary() {
+		telemetry.NotifierDropped.Inc()
+		logger.Error().Msg("Alert rejected by sink, dropping it")
+		return
+	}
+	if d.Attempts >= n.opts.MaxAttempts {
+		telemetry.NotifierDeadLetters.WithLabelValues(sink.Name()).Inc()
+		logger.Error().Msg("Failed to send alert, giving up")
+		n.spool(d)
+		return
+	}
+
+	delay := n.backoff(d.Attempts)
+	if status != nil && status.RetryAfter > 0 {
+		delay = status.RetryAfter
+		n.block(d.route, delay)
+	}
+	logger.Warn().Dur("retry_in", delay).Msg("Failed to send alert, retrying")
+	n.retry(d, delay)
+}
+
+// block holds back every delivery to r for delay, as asked by a Retry-After.
+// A shorter delay never lifts a longer block.
+func (n *Notifier) block(r *route, delay time.Duration) {
+	until := time.Now().Add(delay)
+	n.mu.Lock()
+	if until.After(n.blocked[r]) {
+		n.blocked[r] = until
+	}
+	n.mu.Unlock()
+}
+
+// blockedFor returns how long deliveries to r must still wait, or 0.
+func (n *Notifier) blockedFor(r *route) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	until, ok := n.blocked[r]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		delete(n.blocked, r)
+	}
+	return wait
+}
+
+// backoff returns the delay before the retry following the given number of
+// failed attempts: exponential, capped at maxBackoff, with jitter so sinks
+// recovering from an outage are not hit by every retry at once.
+func (n *Notifier) backoff(attempts int) time.Duration {
+	d := n.opts.Backoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retry queues d again after delay.
+func (n *Notifier) retry(d *delivery, delay time.Duration) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		n.spool(d)
+		return
+	}
+	n.retrying[d] = time.AfterFunc(delay, func() {
+		n.mu.Lock()
+		delete(n.retrying, d)
+		n.mu.Unlock()
+		n.enqueue(d)
+	})
+	n.mu.Unlock()
+}
+
+// spool appends deliveries to the spool file, to be sent again by
+// replaySpool or at the next start. Without a spool file they are lost.
+func (n *Notifier) spool(ds ...*delivery) {
+	if len(ds) == 0 {
+		return
+	}
+	if n.opts.SpoolPath == "" {
+		telemetry.NotifierDropped.Add(float64(len(ds)))
+		n.dropped.add(len(ds))
+		return
+	}
+
+	n.spoolMu.Lock()
+	defer n.spoolMu.Unlock()
+	if err := appendSpool(n.opts.SpoolPath, ds); err != nil {
+		telemetry.NotifierDropped.Add(float64(len(ds)))
+		log.Error().Err(err).Int("alerts", len(ds)).Msg("Failed to spool undelivered alerts")
+		return
+	}
+	telemetry.NotifierSpooled.Add(float64(len(ds)))
+	n.spooled.add(len(ds))
+}
+
+func appendSpool(path string, ds []*delivery) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("creating spool directory: %w", err)
+		}
+	}
+	// The spool holds captured credentials, so keep it private
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, d := range ds {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadSpool queues the deliveries of the spool file again and removes it.
+// Deliveries to sinks that are no longer configured, or older than
+// spoolMaxAge, are dropped.
+func (n *Notifier) loadSpool() error {
+	if n.opts.SpoolPath == "" {
+		return nil
+	}
+	n.spoolMu.Lock()
+	ds, err := takeSpool(n.opts.SpoolPath)
+	n.spoolMu.Unlock()
+	if err != nil || len(ds) == 0 {
+		return err
+	}
+
+	routes := make(map[string]*route, len(n.routes))
+	for i := range n.routes {
+		routes[n.routes[i].id] = &n.routes[i]
+	}
+	var queued, dropped int
+	for _, d := range ds {
+		r, ok := routes[d.Sink]
+		if ok && d.Digest != nil {
+			_, ok = r.sink.(DigestSink)
+		}
+		if !ok || time.Since(d.time()) > spoolMaxAge {
+			dropped++
+			continue
+		}
+		d.route = r
+		d.Attempts = 0
+		n.enqueue(d)
+		queued++
+	}
+	telemetry.NotifierDropped.Add(float64(dropped))
+	log.Info().Int("alerts", queued).Int("dropped", dropped).Msg("Sending spooled alerts")
+	return nil
+}
+
+// replaySpool sends the spooled deliveries again every SpoolRetry, so alerts
+// given up on during an outage go out once the sink is back.
+func (n *Notifier) replaySpool() {
+	defer n.workers.Done()
+	ticker := time.NewTicker(n.opts.SpoolRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.loadSpool(); err != nil {
+				log.Error().Err(err).Str("path", n.opts.SpoolPath).Msg("Failed to read the alert spool")
+			}
+		}
+	}
+}
+
+// takeSpool reads the spool file and removes it, so deliveries spooled again
+// while being sent start a new file.
+func takeSpool(path string) ([]*delivery, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ds []*delivery
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		d := new(delivery)
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			log.Warn().Err(err).Msg("Skipping a corrupt line of the alert spool")
+			continue
+		}
+		ds = append(ds, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ds, os.Remove(path)
+}
@@ -0,0 +1,188 @@
+package notifier
+
+import (
+	"container/list"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Rate limit keys accepted by RateLimitOptions.
+const (
+	// KeyIP limits alerts per client IP.
+	KeyIP = "ip"
+	// KeyIPTrap limits alerts per client IP and trap type.
+	KeyIPTrap = "ip_trap"
+	// KeySubnet limits alerts per /24 IPv4 or /48 IPv6 network.
+	KeySubnet = "subnet"
+	// KeyFingerprint limits alerts per client fingerprint (JA4, else JA4H),
+	// falling back to the IP for clients without one.
+	KeyFingerprint = "fingerprint"
+	// KeyNone disables rate limiting.
+	KeyNone = "none"
+)
+
+const (
+	defaultRateLimitWindow = time.Hour
+	defaultRateLimitSize   = 100000
+)
+
+// RateLimitOptions configure how repeated alerts about the same client are
+// suppressed.
+type RateLimitOptions struct {
+	// Window is the minimum time between two alerts with the same key. Zero
+	// means one hour.
+	Window time.Duration `koanf:"window"`
+	// Key groups the alerts that share a window: KeyIP, KeyIPTrap,
+	// KeySubnet, KeyFingerprint or KeyNone. Empty means KeyIP.
+	Key string `koanf:"key"`
+	// MaxEntries bounds the keys remembered. The least recently seen are
+	// forgotten first. Zero means 100000.
+	MaxEntries int `koanf:"max_entries"`
+}
+
+// Validate checks the key of the options.
+func (o RateLimitOptions) Validate() error {
+	if _, err := rateLimitKey(o.Key); err != nil {
+		return err
+	}
+	if o.Window < 0 || o.MaxEntries < 0 {
+		return fmt.Errorf("window and max_entries must not be negative")
+	}
+	return nil
+}
+
+func rateLimitKey(name string) (func(Event) string, error) {
+	switch name {
+	case "", KeyIP:
+		return func(e Event) string { return e.IP }, nil
+	case KeyIPTrap:
+		return func(e Event) string { return e.IP + " " + e.Trap }, nil
+	case KeySubnet:
+		return subnetKey, nil
+	case KeyFingerprint:
+		return fingerprintKey, nil
+	case KeyNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("key: unknown rate limit key %q (known keys: %s, %s, %s, %s, %s)", name, KeyIP, KeyIPTrap, KeySubnet, KeyFingerprint, KeyNone)
+}
+
+func subnetKey(e Event) string {
+	ip, err := netip.ParseAddr(e.IP)
+	if err != nil {
+		return e.IP
+	}
+	bits := 48
+	if ip.Is4() {
+		bits = 24
+	}
+	prefix, _ := ip.Prefix(bits)
+	return prefix.String()
+}
+
+func fingerprintKey(e Event) string {
+	switch {
+	case e.Fingerprint.JA4 != "":
+		return "ja4 " + e.Fingerprint.JA4
+	case e.Fingerprint.JA4H != "":
+		return "ja4h " + e.Fingerprint.JA4H
+	}
+	return "ip " + e.IP
+}
+
+// limiter lets one alert per key through every window and counts the
+// others. Keys live in an LRU list: a key unseen for a whole window is
+// forgotten unless it has suppressed alerts to report, and the least
+// recently seen keys beyond the size limit are forgotten regardless.
+type limiter struct {
+	key    func(Event) string
+	window time.Duration
+	size   int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *limit, most recently seen first.
+	lru *list.List
+}
+
+type limit struct {
+	key string
+	// sent is when the last alert went out, seen when the key last showed up
+	// or was last kept by expire.
+	sent, seen time.Time
+	suppressed int
+}
+
+// newLimiter returns nil if rate limiting is disabled.
+func newLimiter(o RateLimitOptions) (*limiter, error) {
+	key, err := rateLimitKey(o.Key)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if o.Window <= 0 {
+		o.Window = defaultRateLimitWindow
+	}
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = defaultRateLimitSize
+	}
+	return &limiter{
+		key:     key,
+		window:  o.Window,
+		size:    o.MaxEntries,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// allow reports whether e may be sent at now. If so, suppressed is the
+// number of alerts with the same key held back since the last one sent. A
+// nil limiter allows everything.
+func (l *limiter) allow(e Event, now time.Time) (ok bool, suppressed int) {
+	if l == nil {
+		return true, 0
+	}
+	key := l.key(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(now)
+
+	if el, found := l.entries[key]; found {
+		lim := el.Value.(*limit)
+		lim.seen = now
+		l.lru.MoveToFront(el)
+		if now.Sub(lim.sent) < l.window {
+			lim.suppressed++
+			return false, 0
+		}
+		suppressed, lim.suppressed, lim.sent = lim.suppressed, 0, now
+		return true, suppressed
+	}
+
+	l.entries[key] = l.lru.PushFront(&limit{key: key, sent: now, seen: now})
+	for l.lru.Len() > l.size {
+		l.remove(l.lru.Back())
+	}
+	return true, 0
+}
+
+// expire forgets the keys unseen for a whole window. Their next alert would
+// go out anyway. Keys with suppressed alerts are kept for another window
+// instead, so their next alert still reports the count.
+func (l *limiter) expire(now time.Time) {
+	for el := l.lru.Back(); el != nil && now.Sub(el.Value.(*limit).seen) >= l.window; el = l.lru.Back() {
+		if lim := el.Value.(*limit); lim.suppressed > 0 {
+			lim.seen = now
+			l.lru.MoveToFront(el)
+			continue
+		}
+		l.remove(el)
+	}
+}
+
+func (l *limiter) remove(el *list.Element) {
+	l.lru.Remove(el)
+	delete(l.entries, el.Value.(*limit).key)
+}
@@ -0,0 +1,229 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Sink delivers alerts to one destination.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Send delivers e. Failed deliveries are retried unless the error is a
+	// *StatusError that is not Temporary.
+	Send(ctx context.Context, e Event) error
+}
+
+// DigestSink is a Sink that can also deliver digests. Routes to sinks that
+// are not get every alert on its own, even in digest mode.
+type DigestSink interface {
+	Sink
+	SendDigest(ctx context.Context, d Digest) error
+}
+
+// StatusError is returned by sinks whose service answered with an HTTP error.
+type StatusError struct {
+	Code   int
+	Status string
+	// RetryAfter is the delay asked for by a 429 or 503 response, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return "webhook returned " + e.Status
+}
+
+// Temporary reports whether the request may succeed if sent again.
+func (e *StatusError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code == http.StatusRequestTimeout || e.Code >= 500
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP date.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	// Discord may send fractional seconds
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Sink types accepted by SinkConfig.
+const (
+	SinkSlack   = "slack"
+	SinkDiscord = "discord"
+	SinkTeams   = "teams"
+	SinkWebhook = "webhook"
+)
+
+// SinkConfig configures one destination of alerts.
+type SinkConfig struct {
+	// Type is one of SinkSlack, SinkDiscord, SinkTeams or SinkWebhook.
+	Type string `koanf:"type"`
+	// URL is the incoming webhook of the service.
+	URL string `koanf:"url"`
+	// Traps limits the sink to alerts of these trap types. Glob patterns such
+	// as "*_tarpit" are allowed. Empty means every trap.
+	Traps []string `koanf:"traps"`
+	// MinSeverity drops alerts below this severity.
+	MinSeverity string `koanf:"min_severity"`
+}
+
+// NewSink creates the sink described by c, posting with client.
+func NewSink(c SinkConfig, client *http.Client) (Sink, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url: %q is not an http(s) URL", c.URL)
+	}
+	w := webhook{name: c.Type + " " + u.Host, url: c.URL, client: client}
+	switch c.Type {
+	case SinkSlack:
+		return &formatSink{webhook: w, format: slackPayload, digest: slackDigest}, nil
+	case SinkDiscord:
+		return &formatSink{webhook: w, format: discordPayload, digest: discordDigest}, nil
+	case SinkTeams:
+		return &formatSink{webhook: w, format: teamsPayload, digest: teamsDigest}, nil
+	case SinkWebhook:
+		return &formatSink{webhook: w, format: jsonPayload, digest: jsonDigest}, nil
+	}
+	return nil, fmt.Errorf("type: unknown sink type %q (known types: %s, %s, %s, %s)", c.Type, SinkSlack, SinkDiscord, SinkTeams, SinkWebhook)
+}
+
+// route sends the alerts that pass filter to sink.
+type route struct {
+	// id identifies the route across restarts, for spooled alerts.
+	id     string
+	sink   Sink
+	filter filter
+	// digest collects the alerts sent in digests, nil outside digest mode.
+	digest *digester
+}
+
+// routeID derives the ID of a route from its destination, without putting
+// the secret webhook URL in the spool file.
+func routeID(typ, webhookURL string) string {
+	sum := sha256.Sum256([]byte(typ + " " + webhookURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c SinkConfig) route(client *http.Client) (route, error) {
+	sink, err := NewSink(c, client)
+	if err != nil {
+		return route{}, err
+	}
+	min, err := ParseSeverity(c.MinSeverity)
+	if err != nil {
+		return route{}, fmt.Errorf("min_severity: %w", err)
+	}
+	for _, pattern := range c.Traps {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return route{}, fmt.Errorf("traps: bad pattern %q: %w", pattern, err)
+		}
+	}
+	return route{id: routeID(c.Type, c.URL), sink: sink, filter: filter{traps: c.Traps, minSeverity: min}}, nil
+}
+
+// filter selects alerts by trap and severity. The zero filter passes everything.
+type filter struct {
+	traps       []string
+	minSeverity Severity
+}
+
+func (f filter) match(e Event) bool {
+	return e.Severity >= f.minSeverity && f.matchTrap(e.Trap)
+}
+
+func (f filter) matchTrap(trap string) bool {
+	if len(f.traps) == 0 {
+		return true
+	}
+	for _, pattern := range f.traps {
+		if ok, _ := path.Match(pattern, trap); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// webhook posts JSON payloads to an incoming webhook.
+type webhook struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (w webhook) Name() string {
+	return w.name
+}
+
+func (w webhook) post(ctx context.Context, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshalling payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 400 {
+		return &StatusError{
+			Code:       resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	return nil
+}
+
+// formatSink posts alerts and digests in the payload format of one service.
+type formatSink struct {
+	webhook
+	format func(e Event) interface{}
+	digest func(d Digest) interface{}
+}
+
+func (s *formatSink) Send(ctx context.Context, e Event) error {
+	return s.post(ctx, s.format(e))
+}
+
+func (s *formatSink) SendDigest(ctx context.Context, d Digest) error {
+	return s.post(ctx, s.digest(d))
+}
+
+// legacySink posts the payload of the original webhook_url setting, which
+// both Discord (content) and Slack (text) accept.
+func legacySink(webhookURL string, client *http.Client) Sink {
+	return &formatSink{
+		webhook: webhook{name: "webhook_url", url: webhookURL, client: client},
+		format:  legacyPayload,
+		digest:  legacyDigest,
+	}
+}
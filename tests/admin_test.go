@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/admin"
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+)
+
+// adminCall sends an authenticated request and decodes the JSON answer into out.
+func adminCall(t *testing.T, srv *httptest.Server, method, path string, out interface{}) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdmin_Auth(t *testing.T) {
+	srv := httptest.NewServer(admin.New("secret", trap.NewManager(), nil))
+	defer srv.Close()
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/api/traps", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for Authorization %q, got %s", auth, resp.Status)
+		}
+	}
+
+	if code := adminCall(t, srv, "GET", "/api/traps", nil); code != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d", code)
+	}
+	if code := adminCall(t, srv, "GET", "/api/hits", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a store, got %d", code)
+	}
+}
+
+func TestAdmin_PauseTrap(t *testing.T) {
+	var created []*fakeTrap
+	m := trap.NewManager()
+	specs := []trap.Spec{fakeSpec("a", ":1", "one", &created)}
+	if _, err := m.Apply(specs); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(admin.New("secret", m, nil))
+	defer srv.Close()
+
+	status := func() trap.Status {
+		var traps []trap.Status
+		adminCall(t, srv, "GET", "/api/traps", &traps)
+		if len(traps) != 1 {
+			t.Fatalf("Expected 1 trap, got %+v", traps)
+		}
+		return traps[0]
+	}
+
+	if s := status(); !s.Running || s.Paused || s.Name != "a" {
+		t.Fatalf("Expected a running trap, got %+v", s)
+	}
+	if code := adminCall(t, srv, "POST", "/api/traps/a/pause", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 from pause, got %d", code)
+	}
+	if s := status(); s.Running || !s.Paused {
+		t.Errorf("Expected a paused trap, got %+v", s)
+	}
+
+	// A reload leaves it paused
+	if _, err := m.Apply(specs); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s.Running || !s.Paused || len(created) != 1 {
+		t.Errorf("Expected the trap to stay paused across a reload, got %+v", s)
+	}
+
+	if code := adminCall(t, srv, "POST", "/api/traps/a/resume", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 from resume, got %d", code)
+	}
+	if s := status(); !s.Running || s.Paused || len(created) != 2 {
+		t.Errorf("Expected a new running instance, got %+v", s)
+	}
+
+	if code := adminCall(t, srv, "POST", "/api/traps/missing/pause", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown trap, got %d", code)
+	}
+}
+
+func TestAdmin_KickConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = session.NewGroup("admin_test").Listener(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+
+	srv := httptest.NewServer(admin.New("secret", trap.NewManager(), nil))
+	defer srv.Close()
+
+	var live []session.Record
+	adminCall(t, srv, "GET", "/api/connections", &live)
+	var found *session.Record
+	for i := range live {
+		if live[i].Trap == "admin_test" {
+			found = &live[i]
+		}
+	}
+	if found == nil || found.BytesSent != 5 {
+		t.Fatalf("Expected the live connection, got %+v", live)
+	}
+
+	path := "/api/connections/" + strconv.FormatUint(found.ID, 10)
+	if code := adminCall(t, srv, "DELETE", path, nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 from kick, got %d", code)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if b, _ := io.ReadAll(client); string(b) != "hello" {
+		t.Errorf("Expected the connection to be closed after its data, got %q", b)
+	}
+	if code := adminCall(t, srv, "DELETE", path, nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a closed connection, got %d", code)
+	}
+}
+
+func TestAdmin_History(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s := openStore(t, store.Options{Path: path})
+	now := time.Now()
+	s.Record(store.Event{Time: now.Add(-2 * time.Hour), Kind: store.KindHit, Trap: "http_infinite", IP: "192.0.2.1"})
+	s.Record(store.Event{Time: now.Add(-time.Minute), Kind: store.KindHit, Trap: "http_infinite", IP: "192.0.2.1"})
+	s.Record(store.Event{Time: now.Add(-time.Minute), Kind: store.KindHit, Trap: "login_trap", IP: "192.0.2.2"})
+	s.Record(store.Event{Time: now, Kind: store.KindCredential, Trap: "login_trap", IP: "192.0.2.2", Username: "root"})
+	s.Close()
+	s = openStore(t, store.Options{Path: path})
+	defer s.Close()
+
+	srv := httptest.NewServer(admin.New("secret", trap.NewManager(), s))
+	defer srv.Close()
+
+	var hits []store.Event
+	adminCall(t, srv, "GET", "/api/hits?ip=192.0.2.1", &hits)
+	if len(hits) != 2 {
+		t.Errorf("Expected 2 hits from 192.0.2.1, got %+v", hits)
+	}
+	adminCall(t, srv, "GET", "/api/hits?since=1h", &hits)
+	if len(hits) != 2 {
+		t.Errorf("Expected 2 hits in the last hour, got %+v", hits)
+	}
+
+	var creds []store.Event
+	adminCall(t, srv, "GET", "/api/credentials", &creds)
+	if len(creds) != 1 || creds[0].Username != "root" {
+		t.Errorf("Expected the captured credential, got %+v", creds)
+	}
+
+	var top []store.IPCount
+	adminCall(t, srv, "GET", "/api/top-ips", &top)
+	if len(top) != 2 || top[0].IP != "192.0.2.1" || top[0].Hits != 2 || top[1].Hits != 1 {
+		t.Errorf("Expected 192.0.2.1 on top with 2 hits, got %+v", top)
+	}
+	adminCall(t, srv, "GET", "/api/top-ips?since=1h&limit=1", &top)
+	if len(top) != 1 || top[0].Hits != 1 {
+		t.Errorf("Expected one IP with 1 hit in the last hour, got %+v", top)
+	}
+
+	if code := adminCall(t, srv, "GET", "/api/hits?since=yesterday", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad time, got %d", code)
+	}
+}
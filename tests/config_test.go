@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+)
+
+// writeConfig creates a temporary YAML config file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig_Corpora(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("some words here"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := config.Load(writeConfig(t, `
+heffalump:
+  order: 3
+corpora:
+  prose:
+    file: "`+filepath.Join(dir, "a.txt")+`"
+  api:
+    glob: "`+filepath.Join(dir, "*.txt")+`"
+    order: 1
+traps:
+  http_infinite:
+    enabled: true
+    corpus: prose
+  json_infinite:
+    enabled: true
+    corpus: api
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got := cfg.Corpora["prose"].Order; got != 3 {
+		t.Errorf("Expected prose to inherit order 3, got %d", got)
+	}
+	paths, err := cfg.Corpora["api"].Paths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("Expected glob to match 2 files, got %v", paths)
+	}
+}
+
+func TestConfig_CorporaValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "undefined corpus",
+			config: `
+corpora:
+  prose:
+    file: "../assets/corpus.txt"
+traps:
+  spider_trap:
+    enabled: true
+    corpus: directories
+`,
+			wantErr: `traps.spider_trap: corpus "directories" is not defined`,
+		},
+		{
+			name: "no default corpus",
+			config: `
+corpora:
+  prose:
+    file: "../assets/corpus.txt"
+traps:
+  - type: http_infinite
+    addr: ":8080"
+`,
+			wantErr: `traps.http_infinite: corpus "default" is not defined`,
+		},
+		{
+			name: "no default corpus for a route",
+			config: `
+corpora:
+  prose:
+    file: "../assets/corpus.txt"
+traps:
+  - type: http_router
+    addr: ":8080"
+    routes:
+      - path: /
+        trap: spider_trap
+`,
+			wantErr: `traps.http_router: routes[0]: corpus "default" is not defined`,
+		},
+		{
+			name: "two sources",
+			config: `
+corpora:
+  prose:
+    file: "../assets/corpus.txt"
+    glob: "../assets/*.txt"
+`,
+			wantErr: "exactly one of file, glob or compiled",
+		},
+		{
+			name: "missing file",
+			config: `
+corpora:
+  prose:
+    file: "does/not/exist.txt"
+`,
+			wantErr: "corpora.prose",
+		},
+		{
+			name: "empty glob",
+			config: `
+corpora:
+  prose:
+    glob: "does/not/*.txt"
+`,
+			wantErr: "matches no files",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Load(writeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfig_TrapInstances(t *testing.T) {
+	cfg, err := config.Load(writeConfig(t, `
+corpora:
+  default:
+    file: "../assets/corpus.txt"
+traps:
+  - type: http_infinite
+    name: web
+    addr: ":8080"
+    server_name: nginx
+  - type: http_infinite
+    name: web-alt
+    addr: ":8088"
+    throttle:
+      bytes_per_second: 10
+  - type: ssh_tarpit
+    addr: ":2222"
+    enabled: false
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(cfg.Traps) != 3 {
+		t.Fatalf("Expected 3 trap instances, got %d", len(cfg.Traps))
+	}
+	web, alt, ssh := cfg.Traps[0], cfg.Traps[1], cfg.Traps[2]
+	if web.Name != "web" || web.Type != "http_infinite" || web.Addr != ":8080" || !web.Enabled {
+		t.Errorf("Unexpected first instance %+v", web)
+	}
+	if web.Options["server_name"] != "nginx" {
+		t.Errorf("Expected server_name to be kept as an option, got %v", web.Options)
+	}
+	if alt.Name != "web-alt" || alt.Options["throttle"] == nil {
+		t.Errorf("Unexpected second instance %+v", alt)
+	}
+	if ssh.Name != "ssh_tarpit" || ssh.Enabled {
+		t.Errorf("Expected the name to default to the type and enabled: false to stick, got %+v", ssh)
+	}
+}
+
+func TestConfig_TrapInstancesValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "duplicate name",
+			config: `
+corpora:
+  default:
+    file: "../assets/corpus.txt"
+traps:
+  - type: http_infinite
+    addr: ":8080"
+  - type: http_infinite
+    addr: ":8081"
+`,
+			wantErr: "traps.http_infinite: name is used by several traps",
+		},
+		{
+			name: "missing type",
+			config: `
+traps:
+  - addr: ":8080"
+`,
+			wantErr: "traps[0]: type is required",
+		},
+		{
+			name: "proxy protocol without trusted proxies",
+			config: `
+corpora:
+  default:
+    file: "../assets/corpus.txt"
+traps:
+  - type: ssh_tarpit
+    addr: ":2222"
+    proxy_protocol: true
+`,
+			wantErr: "traps.ssh_tarpit: proxy_protocol requires proxies.trusted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Load(writeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/fingerprint"
+)
+
+// clientHello builds the TLS records of a ClientHello with the given
+// ciphers and extensions (type and body), split into records of at most
+// recordSize bytes.
+func clientHello(ciphers []uint16, exts [][2]interface{}, recordSize int) []byte {
+	u16 := func(b []byte, v uint16) []byte { return binary.BigEndian.AppendUint16(b, v) }
+
+	body := u16(nil, 0x0303)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session id
+	body = u16(body, uint16(2*len(ciphers)))
+	for _, c := range ciphers {
+		body = u16(body, c)
+	}
+	body = append(body, 1, 0) // null compression
+
+	var extBytes []byte
+	for _, e := range exts {
+		data := e[1].([]byte)
+		extBytes = u16(extBytes, e[0].(uint16))
+		extBytes = u16(extBytes, uint16(len(data)))
+		extBytes = append(extBytes, data...)
+	}
+	body = u16(body, uint16(len(extBytes)))
+	body = append(body, extBytes...)
+
+	msg := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+
+	var records []byte
+	for len(msg) > 0 {
+		n := min(len(msg), recordSize)
+		records = append(records, 0x16, 0x03, 0x01)
+		records = u16(records, uint16(n))
+		records = append(records, msg[:n]...)
+		msg = msg[n:]
+	}
+	return records
+}
+
+func u16List(prefixBytes int, values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	if prefixBytes == 1 {
+		return append([]byte{byte(len(b))}, b...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
+
+// chromeHello resembles the Chrome ClientHello used as the JA4 example,
+// with GREASE values that must be ignored.
+func chromeHello(recordSize int) []byte {
+	sni := []byte("\x00\x0e\x00\x00\x0bexample.com")
+	alpn := []byte("\x00\x0c\x02h2\x08http/1.1")
+	return clientHello(
+		[]uint16{0x2a2a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		[][2]interface{}{
+			{uint16(0x0a0a), []byte{}},
+			{uint16(0x0000), sni},
+			{uint16(0x0017), []byte{}},
+			{uint16(0xff01), []byte{0}},
+			{uint16(0x000a), u16List(2, 0x3a3a, 0x001d, 0x0017, 0x0018)},
+			{uint16(0x000b), []byte{1, 0}},
+			{uint16(0x0023), []byte{}},
+			{uint16(0x0010), alpn},
+			{uint16(0x0005), []byte{1, 0, 0, 0, 0}},
+			{uint16(0x000d), u16List(2, 0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601)},
+			{uint16(0x0012), []byte{}},
+			{uint16(0x0033), []byte{0, 0}},
+			{uint16(0x002d), []byte{1, 1}},
+			{uint16(0x002b), u16List(1, 0x6a6a, 0x0304, 0x0303)},
+			{uint16(0x001b), []byte{2, 0, 2}},
+			{uint16(0x4469), []byte{}},
+			{uint16(0x0015), []byte{0, 0}},
+		}, recordSize)
+}
+
+func TestFingerprint_ClientHello(t *testing.T) {
+	// Split over several records, which the parser has to reassemble
+	for _, size := range []int{16384, 100} {
+		data := chromeHello(size)
+
+		if _, err := fingerprint.ParseClientHello(data[:len(data)-1]); !errors.Is(err, fingerprint.ErrIncomplete) {
+			t.Fatalf("expected ErrIncomplete for a truncated hello, got %v", err)
+		}
+
+		hello, err := fingerprint.ParseClientHello(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello.ServerName != "example.com" || len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" {
+			t.Errorf("unexpected SNI %q or ALPN %v", hello.ServerName, hello.ALPN)
+		}
+
+		fp := hello.Fingerprint()
+		if want := "t13d1516h2_8daaf6152771_e5627efa2ab1"; fp.JA4 != want {
+			t.Errorf("expected JA4 %s, got %s", want, fp.JA4)
+		}
+		wantJA3 := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+			"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0"
+		if fp.JA3Full != wantJA3 {
+			t.Errorf("expected JA3 string\n%s\ngot\n%s", wantJA3, fp.JA3Full)
+		}
+		if len(fp.JA3) != 32 {
+			t.Errorf("expected an MD5 JA3 hash, got %q", fp.JA3)
+		}
+	}
+
+	if _, err := fingerprint.ParseClientHello([]byte("GET / HTTP/1.1\r\n")); !errors.Is(err, fingerprint.ErrNotClientHello) {
+		t.Errorf("expected plain HTTP to be rejected, got %v", err)
+	}
+}
+
+func TestFingerprint_JA4H(t *testing.T) {
+	raw := "Host: example.com\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0\r\n" +
+		"Accept: text/html\r\n" +
+		"Accept-Language: en-US,en;q=0.5\r\n" +
+		"Accept-Encoding: gzip, deflate, br\r\n" +
+		"Cookie: session=abc; lang=en\r\n" +
+		"Referer: https://example.com/\r\n"
+	headers := fingerprint.ParseHeaders([]byte(raw))
+	if len(headers) != 7 || headers[5].Value != "session=abc; lang=en" {
+		t.Fatalf("unexpected headers %+v", headers)
+	}
+
+	fp := fingerprint.NewHTTP("GET", "HTTP/1.1", headers)
+	want := "ge11cr05enus_" + sha12("Host,User-Agent,Accept,Accept-Language,Accept-Encoding") +
+		"_" + sha12("lang,session") + "_" + sha12("lang=en,session=abc") + "_" + sha12("gzip,deflate,br")
+	if fp.JA4H != want {
+		t.Errorf("expected JA4H %s, got %s", want, fp.JA4H)
+	}
+	if fp.Class != fingerprint.ClassBrowser {
+		t.Errorf("expected a browser, got %s", fp.Class)
+	}
+
+	// The header order is part of the fingerprint
+	reordered := fingerprint.NewHTTP("GET", "HTTP/1.1", append([]fingerprint.Header{headers[1], headers[0]}, headers[2:]...))
+	if reordered.JA4H == fp.JA4H {
+		t.Error("expected a different fingerprint for a different header order")
+	}
+
+	// The accepted encodings are part of the fingerprint, but not their case or weights
+	encodings := slices.Clone(headers)
+	encodings[4].Value = "br, zstd"
+	if other := fingerprint.NewHTTP("GET", "HTTP/1.1", encodings); other.JA4H == fp.JA4H {
+		t.Error("expected a different fingerprint for different encodings")
+	}
+	encodings[4].Value = "GZIP;q=1.0, deflate , br;q=0.5"
+	if same := fingerprint.NewHTTP("GET", "HTTP/1.1", encodings); same.JA4H != fp.JA4H {
+		t.Errorf("expected normalized encodings to keep JA4H %s, got %s", fp.JA4H, same.JA4H)
+	}
+
+	bare := fingerprint.NewHTTP("POST", "HTTP/1.0", fingerprint.ParseHeaders([]byte("Host: x\r\n")))
+	if want := "po10nn010000_" + sha12("Host") + "_000000000000_000000000000_000000000000"; bare.JA4H != want {
+		t.Errorf("expected JA4H %s, got %s", want, bare.JA4H)
+	}
+}
+
+func TestFingerprint_Classify(t *testing.T) {
+	browser := []fingerprint.Header{
+		{Name: "Accept-Language", Value: "de-DE"},
+		{Name: "Accept-Encoding", Value: "gzip, deflate, br, zstd"},
+	}
+	tests := []struct {
+		ua      string
+		browser bool
+		want    string
+	}{
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", false, fingerprint.ClassAICrawler},
+		{"Mozilla/5.0 (compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", false, fingerprint.ClassAICrawler},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", false, fingerprint.ClassSearchEngine},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Nuclei - Open-source project (github.com/projectdiscovery/nuclei)", false, fingerprint.ClassScanner},
+		{"Mozilla/5.0 zgrab/0.x", false, fingerprint.ClassScanner},
+		{"curl/8.5.0", false, fingerprint.ClassScript},
+		{"python-requests/2.31.0", false, fingerprint.ClassScript},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0", true, fingerprint.ClassBrowser},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0", false, fingerprint.ClassFakeBrowser},
+		{"", false, fingerprint.ClassUnknown},
+		{"SomethingElse/1.0", false, fingerprint.ClassUnknown},
+	}
+	for _, tt := range tests {
+		headers := []fingerprint.Header{{Name: "User-Agent", Value: tt.ua}}
+		if tt.browser {
+			headers = append(headers, browser...)
+		}
+		if got := fingerprint.Classify(headers); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.ua, tt.want, got)
+		}
+	}
+}
+
+func sha12(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/governor"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestGovernor_Disabled(t *testing.T) {
+	g, err := governor.New(governor.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != nil {
+		t.Fatal("Expected nil governor when no limits are configured")
+	}
+
+	// A nil governor admits everything
+	release, _, ok := g.Acquire(tcpAddr("192.0.2.1"))
+	if !ok {
+		t.Fatal("Nil governor rejected a connection")
+	}
+	release()
+}
+
+func TestGovernor_Limits(t *testing.T) {
+	g, err := governor.New(governor.Options{MaxTotal: 4, MaxPerIP: 2, MaxPerPrefix: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. Per IP limit
+	r1, _, ok1 := g.Acquire(tcpAddr("192.0.2.1"))
+	r2, _, ok2 := g.Acquire(tcpAddr("192.0.2.1"))
+	if !ok1 || !ok2 {
+		t.Fatal("Connections within the per-IP limit were rejected")
+	}
+	if _, limit, ok := g.Acquire(tcpAddr("192.0.2.1")); ok || limit != "ip" {
+		t.Errorf("Expected per-IP rejection, got ok=%v limit=%q", ok, limit)
+	}
+
+	// 2. Per /24 limit
+	r3, _, ok3 := g.Acquire(tcpAddr("192.0.2.2"))
+	if !ok3 {
+		t.Fatal("Connection within the prefix limit was rejected")
+	}
+	if _, limit, ok := g.Acquire(tcpAddr("192.0.2.3")); ok || limit != "prefix" {
+		t.Errorf("Expected per-prefix rejection, got ok=%v limit=%q", ok, limit)
+	}
+
+	// 3. Total limit
+	r4, _, ok4 := g.Acquire(tcpAddr("198.51.100.1"))
+	if !ok4 {
+		t.Fatal("Connection within the total limit was rejected")
+	}
+	if _, limit, ok := g.Acquire(tcpAddr("203.0.113.1")); ok || limit != "total" {
+		t.Errorf("Expected total rejection, got ok=%v limit=%q", ok, limit)
+	}
+
+	// 4. Releasing frees the slot (twice is harmless)
+	r1()
+	r1()
+	if r, _, ok := g.Acquire(tcpAddr("192.0.2.1")); !ok {
+		t.Error("Released slot was not reusable")
+	} else {
+		r()
+	}
+
+	r2()
+	r3()
+	r4()
+}
+
+func TestGovernor_InvalidOverflow(t *testing.T) {
+	if _, err := governor.New(governor.Options{MaxTotal: 1, Overflow: "explode"}); err == nil {
+		t.Error("Expected error for unknown overflow action")
+	}
+}
+
+func TestGovernor_MaxHeld(t *testing.T) {
+	g, err := governor.New(governor.Options{MaxTotal: 1, Overflow: governor.OverflowHold, MaxHeld: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := g.Listener(inner, "test_trap", nil)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	// readErr returns how a read on conn ends within 300ms
+	readErr := func(conn net.Conn) error {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	// 1. The first connection is served, the second held
+	dial()
+	time.Sleep(50 * time.Millisecond)
+	held := dial()
+	time.Sleep(50 * time.Millisecond)
+
+	// 2. Beyond max_held, overflow connections are rejected
+	rejected := dial()
+	if err := readErr(rejected); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected the connection beyond max_held to be closed, got %v", err)
+	}
+	var ne net.Error
+	if err := readErr(held); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Expected the held connection to stay open, got %v", err)
+	}
+}
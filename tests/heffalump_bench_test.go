@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+)
+
+// benchCorpus returns the corpus used by the chain benchmarks.
+// Set VOIDSINK_BENCH_CORPUS to measure against a larger corpus.
+func benchCorpus() string {
+	if path := os.Getenv("VOIDSINK_BENCH_CORPUS"); path != "" {
+		return path
+	}
+	return "../assets/corpus.txt"
+}
+
+// legacyChain is the original string-keyed second-order chain, kept here as
+// the baseline for the interned implementation.
+type legacyChain struct {
+	chain map[string][]string
+	keys  []string
+	rnd   *rand.Rand
+}
+
+func newLegacyChain(path string) (*legacyChain, error) {
+	c := &legacyChain{
+		chain: make(map[string][]string),
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Split(heffalump.ScanHTML)
+
+	var w1, w2 string
+	if scanner.Scan() {
+		w1 = scanner.Text()
+	}
+	if scanner.Scan() {
+		w2 = scanner.Text()
+	}
+	for scanner.Scan() {
+		w3 := scanner.Text()
+		key := w1 + " " + w2
+		c.chain[key] = append(c.chain[key], w3)
+		w1, w2 = w2, w3
+	}
+
+	c.keys = make([]string, 0, len(c.chain))
+	for k := range c.chain {
+		c.keys = append(c.keys, k)
+	}
+	return c, scanner.Err()
+}
+
+func (c *legacyChain) next(w1, w2 string) string {
+	candidates, ok := c.chain[w1+" "+w2]
+	if !ok {
+		candidates = c.chain[c.keys[c.rnd.Intn(len(c.keys))]]
+	}
+	return candidates[c.rnd.Intn(len(candidates))]
+}
+
+// retainedHeap returns how many heap bytes the value built by fn keeps alive.
+func retainedHeap(b *testing.B, fn func() interface{}) uint64 {
+	b.Helper()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := fn()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+func BenchmarkChainBuild(b *testing.B) {
+	path := benchCorpus()
+
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := newLegacyChain(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(retainedHeap(b, func() interface{} {
+			c, _ := newLegacyChain(path)
+			return c
+		})), "retained-B")
+	})
+
+	for _, order := range []int{1, 2, 3, 4} {
+		b.Run(fmt.Sprintf("interned/order=%d", order), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := heffalump.NewWithOptions(path, heffalump.Options{Order: order}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(retainedHeap(b, func() interface{} {
+				h, _ := heffalump.NewWithOptions(path, heffalump.Options{Order: order})
+				return h
+			})), "retained-B")
+		})
+	}
+}
+
+func BenchmarkChainGenerate(b *testing.B) {
+	path := benchCorpus()
+
+	b.Run("legacy", func(b *testing.B) {
+		c, err := newLegacyChain(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w1, w2 := "", ""
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			w3 := c.next(w1, w2)
+			w1, w2 = w2, w3
+		}
+	})
+
+	b.Run("interned", func(b *testing.B) {
+		h, err := heffalump.New(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stream := h.NewStream()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			stream.Next()
+		}
+	})
+}
+
+// BenchmarkChainLoad compares startup from raw text with a compiled chain.
+func BenchmarkChainLoad(b *testing.B) {
+	path := benchCorpus()
+	h, err := heffalump.New(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	compiled := filepath.Join(b.TempDir(), "corpus.chain")
+	if err := h.Save(compiled); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct{ name, path string }{{"text", path}, {"compiled", compiled}} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := heffalump.New(bc.path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,8 +2,15 @@ package tests
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
@@ -81,3 +88,240 @@ func TestHeffalump_Integration(t *testing.T) {
 		t.Error("Next() returned empty string for unknown sequence")
 	}
 }
+
+// writeCorpus creates a temporary corpus file and returns its path.
+func writeCorpus(t testing.TB, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestHeffalump_ConcurrentStreams runs many streams at once.
+// Run with -race to verify streams share no mutable state.
+func TestHeffalump_ConcurrentStreams(t *testing.T) {
+	h, err := heffalump.New(writeCorpus(t, "The quick brown fox jumps over the lazy dog and the quick red fox runs away"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream := h.NewStream()
+			for j := 0; j < 1000; j++ {
+				if stream.Next() == "" {
+					t.Error("Stream returned an empty word")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkHeffalump_ParallelStreams(b *testing.B) {
+	h, err := heffalump.New("../assets/corpus.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		stream := h.NewStream()
+		for pb.Next() {
+			stream.Next()
+		}
+	})
+}
+
+// TestHeffalump_StreamReader verifies the io.Reader and io.WriterTo APIs.
+func TestHeffalump_StreamReader(t *testing.T) {
+	h, err := heffalump.New(writeCorpus(t, "alpha beta gamma delta epsilon zeta eta theta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. Read fills the whole buffer, even when words don't line up with it
+	stream := h.NewStream()
+	buf := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		n, err := stream.Read(buf[:7+i])
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if n != 7+i {
+			t.Errorf("Expected %d bytes, got %d", 7+i, n)
+		}
+	}
+
+	// 2. io.Copy uses WriteTo and stops at the first write error
+	var out bytes.Buffer
+	_, err = io.Copy(&limitedWriter{w: &out, remaining: 10000}, h.NewStream())
+	if err != errLimitReached {
+		t.Fatalf("Expected copy to stop with errLimitReached, got %v", err)
+	}
+	if out.Len() < 10000 {
+		t.Errorf("Expected at least 10000 bytes, got %d", out.Len())
+	}
+	for _, word := range strings.Fields(out.String()) {
+		if !strings.Contains("alpha beta gamma delta epsilon zeta eta theta", word) {
+			t.Errorf("Unexpected word %q in stream", word)
+			break
+		}
+	}
+}
+
+var errLimitReached = errors.New("limit reached")
+
+// limitedWriter accepts writes until remaining drops to zero, like a client
+// that hangs up after a while.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errLimitReached
+	}
+	l.remaining -= len(p)
+	return l.w.Write(p)
+}
+
+// BenchmarkHeffalump_NextLoop is the per-word loop the traps used before the
+// Stream API: one Next call and buffer write per word.
+func BenchmarkHeffalump_NextLoop(b *testing.B) {
+	h, err := heffalump.New("../assets/corpus.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := heffalump.NewBufferPool().Get()
+	w1, w2 := h.Seed()
+
+	b.SetBytes(4000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		for buf.Len() < 4000 {
+			w3 := h.Next(w1, w2)
+			buf.WriteString(w3)
+			buf.WriteByte(' ')
+			w1, w2 = w2, w3
+		}
+	}
+}
+
+// BenchmarkHeffalump_StreamRead reads the same amount of text through io.Reader.
+func BenchmarkHeffalump_StreamRead(b *testing.B) {
+	h, err := heffalump.New("../assets/corpus.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	stream := h.NewStream()
+	buf := make([]byte, 4000)
+
+	b.SetBytes(4000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream.Read(buf)
+	}
+}
+
+// TestHeffalump_Orders verifies chains of every supported order.
+func TestHeffalump_Orders(t *testing.T) {
+	path := writeCorpus(t, "one two three four five six seven")
+
+	for order := 1; order <= heffalump.MaxOrder; order++ {
+		h, err := heffalump.NewWithOptions(path, heffalump.Options{Order: order})
+		if err != nil {
+			t.Fatalf("Order %d: %v", order, err)
+		}
+		if h.Order() != order {
+			t.Errorf("Expected order %d, got %d", order, h.Order())
+		}
+
+		// The corpus has no repeated words, so every window has exactly one successor
+		words := []string{"one", "two", "three", "four", "five"}
+		if got := h.Next(words[:order]...); got != words[order] {
+			t.Errorf("Order %d: expected %q after %v, got %q", order, words[order], words[:order], got)
+		}
+	}
+
+	if _, err := heffalump.NewWithOptions(path, heffalump.Options{Order: 5}); err == nil {
+		t.Error("Expected error for order 5")
+	}
+}
+
+// TestHeffalump_CompiledRoundTrip saves a chain and loads it back.
+func TestHeffalump_CompiledRoundTrip(t *testing.T) {
+	src, err := heffalump.NewWithOptions(writeCorpus(t, "The quick brown fox jumps over the lazy dog"), heffalump.Options{Order: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. Compile
+	path := filepath.Join(t.TempDir(), "corpus.chain")
+	if err := src.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if ok, err := heffalump.IsCompiled(path); err != nil || !ok {
+		t.Fatalf("IsCompiled = %v, %v; want true", ok, err)
+	}
+
+	// 2. Load it back; New detects the format on its own
+	h, err := heffalump.New(path)
+	if err != nil {
+		t.Fatalf("Failed to load compiled chain: %v", err)
+	}
+	if h.Order() != 3 {
+		t.Errorf("Expected order 3, got %d", h.Order())
+	}
+	if next := h.Next("The", "quick", "brown"); next != "fox" {
+		t.Errorf("Expected 'fox', got %q", next)
+	}
+
+	// 3. A conflicting order is rejected
+	if _, err := heffalump.NewWithOptions(path, heffalump.Options{Order: 2}); err == nil {
+		t.Error("Expected error when loading with a different order")
+	}
+
+	// 4. Corruption is detected by the checksum
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heffalump.New(path); err == nil {
+		t.Error("Expected checksum error for corrupted chain")
+	}
+
+	// 5. A chain with a valid checksum but broken weights is rejected too
+	if err := src.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cumulative weights are the last section; zero the first one
+	transitions := int(binary.LittleEndian.Uint32(data[24:]))
+	size := 4 * transitions
+	cum := len(data) - (size + (8-size%8)%8)
+	binary.LittleEndian.PutUint32(data[cum:], 0)
+	binary.LittleEndian.PutUint32(data[40:], crc32.Checksum(data[64:], crc32.MakeTable(crc32.Castagnoli)))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heffalump.New(path); err == nil {
+		t.Error("Expected error for a chain with invalid weights")
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
 	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	httptrap "github.com/Kartikey2011yadav/voidsink/internal/traps/http"
 )
 
 func TestHTTPInfiniteTrap(t *testing.T) {
@@ -32,7 +33,10 @@ func TestHTTPInfiniteTrap(t *testing.T) {
 	// Use a random high port to avoid conflicts
 	addr := "127.0.0.1:54321"
 	serverName := "fake-nginx"
-	tr := trap.NewHTTPInfiniteTrap(addr, serverName, h)
+	tr, err := httptrap.New(addr, trap.HTTPOptions{ServerName: serverName}, h, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// 3. Start Trap in a goroutine
 	ctx, cancel := context.WithCancel(context.Background())
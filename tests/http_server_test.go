@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/valyala/fasthttp"
+)
+
+// TestHTTPServer builds a minimal trap on the shared HTTP runtime.
+func TestHTTPServer(t *testing.T) {
+	addr := "127.0.0.1:54326"
+	opts := trap.DefaultHTTPOptions()
+	opts.ServerName = "Apache"
+	opts.MaxHeaderBytes = 1024
+
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type: "test_trap",
+		Name: "Test Trap",
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("hello")
+		},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("Expected the handler to run, got %q", body)
+	}
+	if got := resp.Header.Get("Server"); got != "Apache" {
+		t.Errorf("Expected Server header %q, got %q", "Apache", got)
+	}
+
+	// The server name can change while running
+	opts.ServerName = "nginx"
+	s.Reconfigure(opts)
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	req.Header.Set("X-Padding", strings.Repeat("a", 4096))
+	resp, err = http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("Expected oversized headers to be rejected, got %s", resp.Status)
+		}
+	}
+
+	resp, err = http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Server"); got != "nginx" {
+		t.Errorf("Expected Server header %q after Reconfigure, got %q", "nginx", got)
+	}
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	httptrap "github.com/Kartikey2011yadav/voidsink/internal/traps/http"
+)
+
+// fakeTrap blocks in Start until cancelled and remembers its settings.
+type fakeTrap struct {
+	value    atomic.Value
+	shutdown atomic.Bool
+}
+
+func (f *fakeTrap) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeTrap) Shutdown(ctx context.Context) error {
+	f.shutdown.Store(true)
+	return nil
+}
+
+func fakeSpec(name, addr, value string, created *[]*fakeTrap) trap.Spec {
+	return trap.Spec{
+		Name:   name,
+		Addr:   addr,
+		Config: value,
+		New: func() (trap.Trap, error) {
+			f := &fakeTrap{}
+			f.value.Store(value)
+			*created = append(*created, f)
+			return f, nil
+		},
+		Reconfigure: func(t trap.Trap) error {
+			t.(*fakeTrap).value.Store(value)
+			return nil
+		},
+	}
+}
+
+func TestManager_Apply(t *testing.T) {
+	var created []*fakeTrap
+	m := trap.NewManager()
+
+	result, err := m.Apply([]trap.Spec{
+		fakeSpec("a", ":1", "one", &created),
+		fakeSpec("b", ":2", "one", &created),
+		fakeSpec("c", ":3", "one", &created),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(result.Started)
+	if !slices.Equal(result.Started, []string{"a", "b", "c"}) || m.Len() != 3 {
+		t.Fatalf("expected a, b and c to start, got %+v", result)
+	}
+
+	// a: unchanged, b: new settings, c: new address, d: new instance, and the
+	// old c is replaced
+	result, err = m.Apply([]trap.Spec{
+		fakeSpec("a", ":1", "one", &created),
+		fakeSpec("b", ":2", "two", &created),
+		fakeSpec("c", ":4", "one", &created),
+		fakeSpec("d", ":5", "one", &created),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Reconfigured, []string{"b"}) ||
+		!slices.Equal(result.Restarted, []string{"c"}) ||
+		!slices.Equal(result.Started, []string{"d"}) ||
+		len(result.Stopped) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if got := created[1].value.Load(); got != "two" {
+		t.Errorf("expected b to be reconfigured in place, got %v", got)
+	}
+	if len(created) != 5 {
+		t.Fatalf("expected 5 traps to be created, got %d", len(created))
+	}
+
+	result, err = m.Apply([]trap.Spec{fakeSpec("a", ":1", "one", &created)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(result.Stopped)
+	if !slices.Equal(result.Stopped, []string{"b", "c", "d"}) || m.Len() != 1 {
+		t.Fatalf("expected b, c and d to stop, got %+v", result)
+	}
+
+	// Stopped traps keep their clients until the manager shuts down
+	for _, f := range created {
+		if f.shutdown.Load() {
+			t.Fatal("trap was shut down before Manager.Shutdown")
+		}
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range created {
+		if !f.shutdown.Load() {
+			t.Errorf("trap %d was not shut down", i)
+		}
+	}
+}
+
+// drainTrap is a fakeTrap that reports its clients.
+type drainTrap struct {
+	fakeTrap
+	active atomic.Int64
+}
+
+func (d *drainTrap) Active() int {
+	return int(d.active.Load())
+}
+
+// waitFor polls cond until it holds or timeout passes.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+// TestManager_RetiresDrainedTraps checks that stopped traps are shut down
+// once their clients leave or the grace period passes, not only at Shutdown.
+func TestManager_RetiresDrainedTraps(t *testing.T) {
+	drainSpec := func(name string, d *drainTrap) trap.Spec {
+		return trap.Spec{Name: name, Addr: ":1", New: func() (trap.Trap, error) { return d, nil }}
+	}
+
+	// 1. A trap that can report its clients is shut down once they leave
+	var created []*fakeTrap
+	m := trap.NewManager()
+	d := &drainTrap{}
+	d.active.Store(1)
+	if _, err := m.Apply([]trap.Spec{drainSpec("a", d), fakeSpec("b", ":2", "one", &created)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Apply(nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if d.shutdown.Load() {
+		t.Fatal("trap was shut down while holding a client")
+	}
+	d.active.Store(0)
+	if !waitFor(3*time.Second, d.shutdown.Load) {
+		t.Error("drained trap was not shut down")
+	}
+	if created[0].shutdown.Load() {
+		t.Error("trap without a client count was shut down before the grace period")
+	}
+	m.Shutdown(context.Background())
+
+	// 2. Clients are cut off once the grace period passes
+	m = trap.NewManager()
+	m.RetireGrace = 200 * time.Millisecond
+	d = &drainTrap{}
+	d.active.Store(1)
+	if _, err := m.Apply([]trap.Spec{drainSpec("a", d)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Pause("a"); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(3*time.Second, d.shutdown.Load) {
+		t.Error("paused trap was not shut down after the grace period")
+	}
+	m.Shutdown(context.Background())
+}
+
+func TestManager_DuplicateName(t *testing.T) {
+	var created []*fakeTrap
+	m := trap.NewManager()
+	_, err := m.Apply([]trap.Spec{
+		fakeSpec("a", ":1", "one", &created),
+		fakeSpec("a", ":2", "one", &created),
+	})
+	if err == nil {
+		t.Fatal("expected an error for duplicate instance names")
+	}
+	if len(created) != 0 {
+		t.Error("no trap should start when the specs are invalid")
+	}
+}
+
+func TestManager_FailedNewChangesNothing(t *testing.T) {
+	var created []*fakeTrap
+	m := trap.NewManager()
+	if _, err := m.Apply([]trap.Spec{fakeSpec("a", ":1", "one", &created)}); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := fakeSpec("b", ":2", "one", &created)
+	broken.New = func() (trap.Trap, error) { return nil, errors.New("no corpus") }
+	if _, err := m.Apply([]trap.Spec{broken}); err == nil {
+		t.Fatal("expected the error of New to be returned")
+	}
+	if m.Len() != 1 || created[0].shutdown.Load() {
+		t.Error("a failed Apply must leave the running traps alone")
+	}
+	m.Shutdown(context.Background())
+}
+
+// TestManager_ReloadKeepsTrappedConnections moves an HTTP trap to a new
+// address and checks that a client trapped by the old listener keeps streaming.
+func TestManager_ReloadKeepsTrappedConnections(t *testing.T) {
+	h, err := heffalump.New(writeCorpus(t, "word1 word2 word3 word4 word5 word6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := func(addr, serverName string) trap.Spec {
+		return trap.Spec{
+			Name:   "http_infinite",
+			Addr:   addr,
+			Config: serverName,
+			New: func() (trap.Trap, error) {
+				return httptrap.New(addr, trap.HTTPOptions{ServerName: serverName}, h, nil, nil, nil)
+			},
+			Reconfigure: func(t trap.Trap) error {
+				t.(*httptrap.HTTPInfiniteTrap).Reconfigure(trap.HTTPOptions{ServerName: serverName}, h, nil)
+				return nil
+			},
+		}
+	}
+
+	oldAddr, newAddr := "127.0.0.1:54324", "127.0.0.1:54325"
+	m := trap.NewManager()
+	if _, err := m.Apply([]trap.Spec{spec(oldAddr, "old")}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://" + oldAddr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := bufio.NewReader(resp.Body)
+	buf := make([]byte, 512)
+	if _, err := body.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.Apply([]trap.Spec{spec(newAddr, "new")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Restarted, []string{"http_infinite"}) {
+		t.Fatalf("expected a restart, got %+v", result)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// The trapped client is still being fed
+	for i := 0; i < 4; i++ {
+		if _, err := body.Read(buf); err != nil {
+			t.Fatalf("trapped connection dropped by reload: %v", err)
+		}
+	}
+
+	if conn, err := net.DialTimeout("tcp", oldAddr, time.Second); err == nil {
+		conn.Close()
+		t.Error("old address still accepts connections")
+	}
+
+	resp2, err := http.Get("http://" + newAddr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if got := resp2.Header.Get("Server"); got != "new" {
+		t.Errorf("expected Server header %q, got %q", "new", got)
+	}
+
+	// Endless streams are cut off once the shutdown deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		m.Shutdown(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after its deadline")
+	}
+}
+
+func TestNewSpec(t *testing.T) {
+	h, err := heffalump.New(writeCorpus(t, "word1 word2 word3 word4 word5 word6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := trap.Deps{
+		Corpus: func(name string) (*heffalump.Heffalump, error) { return h, nil },
+	}
+
+	spec, err := trap.NewSpec(config.TrapConfig{
+		Name:    "web",
+		Type:    "http_infinite",
+		Addr:    "127.0.0.1:0",
+		Options: map[string]interface{}{"server_name": "nginx", "throttle": map[string]interface{}{"jitter": "10ms"}},
+	}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := spec.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.(*httptrap.HTTPInfiniteTrap); !ok {
+		t.Errorf("expected an HTTPInfiniteTrap, got %T", tr)
+	}
+	if spec.Reconfigure == nil {
+		t.Error("expected http_infinite to support reconfiguration")
+	}
+
+	_, err = trap.NewSpec(config.TrapConfig{Name: "web", Type: "http_infinite", Options: map[string]interface{}{"server_nmae": "typo"}}, deps)
+	if err == nil || !strings.Contains(err.Error(), "server_nmae") {
+		t.Errorf("expected unknown options to be rejected, got %v", err)
+	}
+
+	_, err = trap.NewSpec(config.TrapConfig{Name: "x", Type: "telnet_tarpit"}, deps)
+	if err == nil || !strings.Contains(err.Error(), `unknown type "telnet_tarpit"`) {
+		t.Errorf("expected unknown types to be rejected, got %v", err)
+	}
+}
+
+func TestNewSpec_HTTPOptionsRestart(t *testing.T) {
+	deps := trap.Deps{}
+	spec := func(options map[string]interface{}) trap.Spec {
+		s, err := trap.NewSpec(config.TrapConfig{Name: "web", Type: "http_infinite", Addr: ":8080", Options: options}, deps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	base := spec(map[string]interface{}{"server_name": "a"})
+	renamed := spec(map[string]interface{}{"server_name": "b"})
+	slower := spec(map[string]interface{}{"server_name": "a", "read_timeout": "1m"})
+
+	if !reflect.DeepEqual(base.Restart, renamed.Restart) {
+		t.Error("a new server name should not restart the trap")
+	}
+	if reflect.DeepEqual(base.Restart, slower.Restart) {
+		t.Error("a new read timeout should restart the trap")
+	}
+}
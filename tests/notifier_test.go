@@ -0,0 +1,646 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Kartikey2011yadav/voidsink/pkg/notifier"
+)
+
+// webhookServer records the JSON bodies posted to it.
+func webhookServer(t *testing.T) (*httptest.Server, <-chan map[string]interface{}) {
+	t.Helper()
+	bodies := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("Webhook got invalid JSON %q: %v", data, err)
+		}
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func receive(t *testing.T, bodies <-chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(2 * time.Second):
+		t.Fatal("No alert received")
+		return nil
+	}
+}
+
+func TestNotifier_Sinks(t *testing.T) {
+	tests := []struct {
+		typ   string
+		check func(t *testing.T, body map[string]interface{}, raw string)
+	}{
+		{notifier.SinkSlack, func(t *testing.T, body map[string]interface{}, raw string) {
+			blocks, _ := body["blocks"].([]interface{})
+			if len(blocks) < 2 || blocks[0].(map[string]interface{})["type"] != "header" || body["text"] == nil {
+				t.Errorf("Expected Block Kit blocks and a fallback text, got %s", raw)
+			}
+			if strings.Contains(raw, "<script>") || !strings.Contains(raw, "&lt;script&gt;") {
+				t.Errorf("Expected Slack control characters to be escaped, got %s", raw)
+			}
+		}},
+		{notifier.SinkDiscord, func(t *testing.T, body map[string]interface{}, raw string) {
+			embeds, _ := body["embeds"].([]interface{})
+			if len(embeds) != 1 {
+				t.Fatalf("Expected one embed, got %s", raw)
+			}
+			embed := embeds[0].(map[string]interface{})
+			if embed["title"] == nil || embed["color"] == nil || len(embed["fields"].([]interface{})) != 3 {
+				t.Errorf("Expected a titled embed with 3 fields, got %s", raw)
+			}
+		}},
+		{notifier.SinkTeams, func(t *testing.T, body map[string]interface{}, raw string) {
+			attachments, _ := body["attachments"].([]interface{})
+			if body["type"] != "message" || len(attachments) != 1 ||
+				attachments[0].(map[string]interface{})["contentType"] != "application/vnd.microsoft.card.adaptive" {
+				t.Errorf("Expected an Adaptive Card message, got %s", raw)
+			}
+		}},
+		{notifier.SinkWebhook, func(t *testing.T, body map[string]interface{}, raw string) {
+			fp, _ := body["fingerprint"].(map[string]interface{})
+			if body["trap"] != "http_infinite" || body["kind"] != "hit" || body["ip"] != "192.0.2.1" ||
+				body["severity"] != "warning" || fp["ja4h"] != "ge11nn030000_x" {
+				t.Errorf("Expected the alert as JSON, got %s", raw)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			srv, bodies := webhookServer(t)
+			n, err := notifier.New(notifier.Options{Sinks: []notifier.SinkConfig{{Type: tt.typ, URL: srv.URL}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Send(notifier.Event{
+				Trap:        "http_infinite",
+				Kind:        notifier.KindHit,
+				Severity:    notifier.SeverityWarning,
+				IP:          "192.0.2.1",
+				UserAgent:   "<script>`curl`",
+				Fingerprint: notifier.Fingerprint{JA4H: "ge11nn030000_x"},
+			})
+			body := receive(t, bodies)
+			var raw strings.Builder
+			enc := json.NewEncoder(&raw)
+			enc.SetEscapeHTML(false)
+			enc.Encode(body)
+			tt.check(t, body, raw.String())
+		})
+	}
+}
+
+// TestNotifier_OversizedValues sends an alert with a huge user agent through
+// every format to a server enforcing the sinks' size limits, and expects it
+// to be delivered cut rather than rejected.
+func TestNotifier_OversizedValues(t *testing.T) {
+	runes := func(v interface{}) int {
+		s, _ := v.(string)
+		return utf8.RuneCountInString(s)
+	}
+	tests := []struct {
+		name string
+		opts func(url string) notifier.Options
+		// valid reports whether the sink would accept the body
+		valid func(body map[string]interface{}) bool
+	}{
+		{"webhook_url", func(url string) notifier.Options { return notifier.Options{WebhookURL: url} },
+			func(body map[string]interface{}) bool {
+				return runes(body["content"]) <= 2000
+			}},
+		{notifier.SinkSlack, nil, func(body map[string]interface{}) bool {
+			for _, b := range body["blocks"].([]interface{}) {
+				fields, _ := b.(map[string]interface{})["fields"].([]interface{})
+				for _, f := range fields {
+					if runes(f.(map[string]interface{})["text"]) > 2000 {
+						return false
+					}
+				}
+			}
+			return true
+		}},
+		{notifier.SinkDiscord, nil, func(body map[string]interface{}) bool {
+			embed := body["embeds"].([]interface{})[0].(map[string]interface{})
+			total := runes(embed["title"])
+			for _, f := range embed["fields"].([]interface{}) {
+				field := f.(map[string]interface{})
+				if runes(field["value"]) > 1024 {
+					return false
+				}
+				total += runes(field["name"]) + runes(field["value"])
+			}
+			return total <= 6000
+		}},
+		{notifier.SinkTeams, nil, func(body map[string]interface{}) bool {
+			data, _ := json.Marshal(body)
+			return len(data) <= 28*1024
+		}},
+		{notifier.SinkWebhook, nil, func(body map[string]interface{}) bool {
+			// The JSON sink has no limit and keeps the whole value
+			return runes(body["user_agent"]) == 20000
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodies := make(chan map[string]interface{}, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !tt.valid(body) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				bodies <- body
+			}))
+			defer srv.Close()
+
+			opts := notifier.Options{Sinks: []notifier.SinkConfig{{Type: tt.name, URL: srv.URL}}}
+			if tt.opts != nil {
+				opts = tt.opts(srv.URL)
+			}
+			n, err := notifier.New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer n.Close()
+			n.Send(notifier.Event{
+				Trap:        "login_trap",
+				Kind:        notifier.KindCredential,
+				Severity:    notifier.SeverityCritical,
+				IP:          "192.0.2.30",
+				Path:        "/" + strings.Repeat("p", 5000),
+				UserAgent:   strings.Repeat("é<`", 20000/3) + "xy",
+				Credentials: &notifier.Credentials{Username: "admin", Password: strings.Repeat("s", 3000)},
+			})
+			receive(t, bodies)
+		})
+	}
+}
+
+func TestNotifier_Filters(t *testing.T) {
+	all, allBodies := webhookServer(t)
+	login, loginBodies := webhookServer(t)
+	critical, criticalBodies := webhookServer(t)
+
+	n, err := notifier.New(notifier.Options{Sinks: []notifier.SinkConfig{
+		{Type: notifier.SinkWebhook, URL: all.URL},
+		{Type: notifier.SinkWebhook, URL: login.URL, Traps: []string{"login*"}},
+		{Type: notifier.SinkWebhook, URL: critical.URL, MinSeverity: "critical"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Send(notifier.Event{Trap: "ssh_tarpit", Kind: notifier.KindHit, Severity: notifier.SeverityWarning, IP: "192.0.2.10"})
+	n.Send(notifier.Event{
+		Trap:        "login_trap",
+		Kind:        notifier.KindCredential,
+		Severity:    notifier.SeverityCritical,
+		IP:          "192.0.2.11",
+		Credentials: &notifier.Credentials{Username: "admin", Password: "hunter2"},
+	})
+
+	// The unfiltered sink gets both alerts
+	receive(t, allBodies)
+	receive(t, allBodies)
+	if body := receive(t, loginBodies); body["trap"] != "login_trap" {
+		t.Errorf("Expected only the login alert, got %v", body)
+	}
+	body := receive(t, criticalBodies)
+	creds, _ := body["credentials"].(map[string]interface{})
+	if body["kind"] != "credential" || creds["username"] != "admin" || creds["password"] != "hunter2" {
+		t.Errorf("Expected the captured credentials as structured fields, got %v", body)
+	}
+	select {
+	case body := <-loginBodies:
+		t.Errorf("Expected the trap filter to drop the SSH alert, got %v", body)
+	case body := <-criticalBodies:
+		t.Errorf("Expected the severity filter to drop warnings, got %v", body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNotifier_RateLimit(t *testing.T) {
+	srv, bodies := webhookServer(t)
+	n, err := notifier.New(notifier.Options{
+		Sinks:     []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		Workers:   1,
+		RateLimit: notifier.RateLimitOptions{Window: 300 * time.Millisecond, Key: notifier.KeySubnet, MaxEntries: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	hit := func(ip string) {
+		n.Send(notifier.Event{Trap: "http_infinite", Kind: notifier.KindHit, IP: ip})
+	}
+
+	hit("192.0.2.1")
+	hit("192.0.2.2") // Same /24, suppressed
+	hit("192.0.2.3") // Suppressed
+	hit("198.51.100.1")
+	if body := receive(t, bodies); body["ip"] != "192.0.2.1" {
+		t.Errorf("Expected the first alert of the subnet, got %v", body)
+	}
+	if body := receive(t, bodies); body["ip"] != "198.51.100.1" {
+		t.Errorf("Expected the alert of another subnet, got %v", body)
+	}
+
+	// The next alert after the window carries the count
+	time.Sleep(150 * time.Millisecond)
+	hit("192.0.2.4") // Keeps the subnet fresh
+	time.Sleep(200 * time.Millisecond)
+	hit("192.0.2.5")
+	if body := receive(t, bodies); body["ip"] != "192.0.2.5" || body["suppressed"] != 3.0 {
+		t.Errorf("Expected an alert with 3 suppressed, got %v", body)
+	}
+
+	// Beyond MaxEntries the least recently seen subnet is forgotten
+	hit("203.0.113.1")
+	hit("198.18.0.1")
+	hit("192.0.2.6") // Within the window, but forgotten
+	for _, want := range []string{"203.0.113.1", "198.18.0.1", "192.0.2.6"} {
+		if body := receive(t, bodies); body["ip"] != want {
+			t.Errorf("Expected the alert of %s, got %v", want, body)
+		}
+	}
+}
+
+// TestNotifier_RateLimitIdle checks that a client going quiet for longer
+// than the window after a burst still has the burst counted when it returns.
+func TestNotifier_RateLimitIdle(t *testing.T) {
+	srv, bodies := webhookServer(t)
+	n, err := notifier.New(notifier.Options{
+		Sinks:     []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		RateLimit: notifier.RateLimitOptions{Window: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	hit := func() {
+		n.Send(notifier.Event{Trap: "http_infinite", Kind: notifier.KindHit, IP: "192.0.2.40"})
+	}
+
+	// Burst
+	for range 4 {
+		hit()
+	}
+	if body := receive(t, bodies); body["suppressed"] != nil {
+		t.Errorf("Expected the first alert without a count, got %v", body)
+	}
+
+	// Idle for several windows, then return
+	time.Sleep(350 * time.Millisecond)
+	hit()
+	if body := receive(t, bodies); body["suppressed"] != 3.0 {
+		t.Errorf("Expected the returning client's alert to carry 3 suppressed, got %v", body)
+	}
+
+	// Once reported, the count starts over
+	time.Sleep(250 * time.Millisecond)
+	hit()
+	if body := receive(t, bodies); body["suppressed"] != nil {
+		t.Errorf("Expected no suppressed count after it was reported, got %v", body)
+	}
+}
+
+func TestNotifier_Digest(t *testing.T) {
+	srv, bodies := webhookServer(t)
+	n, err := notifier.New(notifier.Options{
+		Sinks:  []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		Digest: notifier.DigestOptions{Interval: 300 * time.Millisecond, Top: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	hit := func(trap, ip, path string) {
+		n.Send(notifier.Event{Trap: trap, Kind: notifier.KindHit, Severity: notifier.SeverityWarning, IP: ip, Path: path, UserAgent: "curl/8.0"})
+	}
+	for range 3 {
+		hit("http_infinite", "192.0.2.1", "/admin")
+	}
+	hit("http_infinite", "192.0.2.2", "/wp-login.php")
+	hit("http_infinite", "192.0.2.3", "/admin")
+	hit("ssh_tarpit", "192.0.2.4", "")
+	for range 2 {
+		n.Send(notifier.Event{
+			Trap:        "login_trap",
+			Kind:        notifier.KindCredential,
+			Severity:    notifier.SeverityCritical,
+			IP:          "192.0.2.9",
+			Credentials: &notifier.Credentials{Username: "admin", Password: "hunter2"},
+		})
+	}
+	n.CountWasted("http_infinite", 2048)
+
+	// Credentials still alert right away, hits only go in the digest
+	if body := receive(t, bodies); body["kind"] != notifier.KindCredential {
+		t.Fatalf("Expected the credential alert first, got %v", body)
+	}
+	body := receive(t, bodies)
+	raw, _ := json.Marshal(body)
+	var d struct {
+		Kind string `json:"kind"`
+		notifier.Digest
+	}
+	if err := json.Unmarshal(raw, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Kind != notifier.KindDigest || d.Events != 8 || d.BytesWasted != 2048 {
+		t.Fatalf("Expected a digest of 8 alerts and 2048 bytes, got %s", raw)
+	}
+	if len(d.Traps) != 3 || d.Traps[0] != (notifier.Count{Value: "http_infinite", Count: 5}) {
+		t.Errorf("Expected alerts counted per trap, got %+v", d.Traps)
+	}
+	if len(d.TopIPs) != 2 || d.TopIPs[0] != (notifier.Count{Value: "192.0.2.1", Count: 3}) {
+		t.Errorf("Expected the top 2 IPs, got %+v", d.TopIPs)
+	}
+	if len(d.TopPaths) != 2 || d.TopPaths[0] != (notifier.Count{Value: "/admin", Count: 4}) {
+		t.Errorf("Expected the top 2 paths, got %+v", d.TopPaths)
+	}
+	if len(d.Credentials) != 1 || d.Credentials[0].Username != "admin" {
+		t.Errorf("Expected the credentials once, got %+v", d.Credentials)
+	}
+}
+
+func TestNotifier_DigestFormats(t *testing.T) {
+	for _, typ := range []string{notifier.SinkSlack, notifier.SinkDiscord, notifier.SinkTeams} {
+		t.Run(typ, func(t *testing.T) {
+			srv, bodies := webhookServer(t)
+			n, err := notifier.New(notifier.Options{
+				Sinks:  []notifier.SinkConfig{{Type: typ, URL: srv.URL}},
+				Digest: notifier.DigestOptions{Interval: 50 * time.Millisecond},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer n.Close()
+			n.Send(notifier.Event{Trap: "http_infinite", Kind: notifier.KindHit, Severity: notifier.SeverityWarning, IP: "192.0.2.1", Path: "/`x`"})
+			body := receive(t, bodies)
+			if body["blocks"] == nil && body["embeds"] == nil && body["attachments"] == nil {
+				t.Errorf("Expected a formatted digest, got %v", body)
+			}
+		})
+	}
+}
+
+func TestNotifier_DigestLongFirstRow(t *testing.T) {
+	for _, typ := range []string{notifier.SinkSlack, notifier.SinkDiscord} {
+		t.Run(typ, func(t *testing.T) {
+			srv, bodies := webhookServer(t)
+			n, err := notifier.New(notifier.Options{
+				Sinks:  []notifier.SinkConfig{{Type: typ, URL: srv.URL}},
+				Digest: notifier.DigestOptions{Interval: 50 * time.Millisecond},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer n.Close()
+			n.Send(notifier.Event{Trap: "http_infinite", Kind: notifier.KindHit, Severity: notifier.SeverityWarning, IP: "192.0.2.1", Path: "/" + strings.Repeat("p", 5000)})
+			data, err := json.Marshal(receive(t, bodies))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The path is cut short instead of leaving its section empty
+			if body := string(data); !strings.Contains(body, "`/"+strings.Repeat("p", 500)) || !strings.Contains(body, "p…`") {
+				t.Errorf("Expected the oversized path to be clipped, got %s", body)
+			}
+			if utf8.RuneCount(data) > 4000 {
+				t.Errorf("Expected the digest to stay within the sink limits, got %d characters", utf8.RuneCount(data))
+			}
+		})
+	}
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	var requests atomic.Int32
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			data, _ := io.ReadAll(r.Body)
+			bodies <- string(data)
+		}
+	}))
+	defer srv.Close()
+
+	n, err := notifier.New(notifier.Options{
+		Sinks:   []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		Backoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	start := time.Now()
+	n.Send(notifier.Event{Trap: "ssh_tarpit", Kind: notifier.KindHit, IP: "192.0.2.20"})
+	select {
+	case body := <-bodies:
+		if !strings.Contains(body, "192.0.2.20") {
+			t.Errorf("Expected the alert to be retried, got %s", body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Alert not delivered after %d attempts", requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the retry to wait for Retry-After, delivered after %v", elapsed)
+	}
+}
+
+func TestNotifier_RetryAfterBlocksSink(t *testing.T) {
+	var requests atomic.Int32
+	var blockedAt atomic.Int64
+	delivered := make(chan time.Time, 5)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			blockedAt.Store(time.Now().UnixNano())
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		delivered <- time.Now()
+	}))
+	defer srv.Close()
+
+	n, err := notifier.New(notifier.Options{
+		Sinks:   []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		Workers: 1,
+		Backoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	for i := range 5 {
+		n.Send(notifier.Event{Trap: "ssh_tarpit", Kind: notifier.KindHit, IP: fmt.Sprintf("192.0.2.%d", 30+i)})
+	}
+	for range 5 {
+		select {
+		case at := <-delivered:
+			if wait := at.Sub(time.Unix(0, blockedAt.Load())); wait < time.Second {
+				t.Errorf("Expected the queued alerts to wait for Retry-After, one was sent after %v", wait)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Alerts not delivered after %d requests", requests.Load())
+		}
+	}
+	if got := requests.Load(); got != 6 {
+		t.Errorf("Expected one rejected request and 5 deliveries, got %d requests", got)
+	}
+}
+
+func TestNotifier_Spool(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		bodies <- string(data)
+	}))
+	defer srv.Close()
+
+	opts := notifier.Options{
+		Sinks:       []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		MaxAttempts: 2,
+		Backoff:     10 * time.Millisecond,
+		SpoolPath:   filepath.Join(t.TempDir(), "alerts.spool"),
+	}
+	n, err := notifier.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Send(notifier.Event{Trap: "login_trap", Kind: notifier.KindCredential, IP: "192.0.2.30"})
+
+	// Given up on after two attempts, the alert is spooled
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(opts.SpoolPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Alert was not spooled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.Close()
+
+	// Sent once the notifier starts again with the webhook back up
+	down.Store(false)
+	n, err = notifier.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	select {
+	case body := <-bodies:
+		if !strings.Contains(body, "192.0.2.30") {
+			t.Errorf("Expected the spooled alert, got %s", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Spooled alert was not sent after a restart")
+	}
+	if _, err := os.Stat(opts.SpoolPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the spool to be emptied, got %v", err)
+	}
+}
+
+// TestNotifier_SpoolReplay checks that spooled alerts are sent again while
+// running once the sink recovers, without a restart.
+func TestNotifier_SpoolReplay(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		bodies <- string(data)
+	}))
+	defer srv.Close()
+
+	opts := notifier.Options{
+		Sinks:       []notifier.SinkConfig{{Type: notifier.SinkWebhook, URL: srv.URL}},
+		MaxAttempts: 1,
+		SpoolPath:   filepath.Join(t.TempDir(), "alerts.spool"),
+		SpoolRetry:  200 * time.Millisecond,
+	}
+	n, err := notifier.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Send(notifier.Event{Trap: "login_trap", Kind: notifier.KindCredential, IP: "192.0.2.31"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(opts.SpoolPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Alert was not spooled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	down.Store(false)
+	select {
+	case body := <-bodies:
+		if !strings.Contains(body, "192.0.2.31") {
+			t.Errorf("Expected the spooled alert, got %s", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Spooled alert was not sent again while running")
+	}
+}
+
+func TestNotifier_Validate(t *testing.T) {
+	bad := []notifier.SinkConfig{
+		{Type: "pager", URL: "https://example.com/hook"},
+		{Type: notifier.SinkSlack, URL: "not a url"},
+		{Type: notifier.SinkDiscord, URL: "https://example.com/hook", MinSeverity: "urgent"},
+		{Type: notifier.SinkTeams, URL: "https://example.com/hook", Traps: []string{"[bad"}},
+	}
+	for _, c := range bad {
+		if _, err := notifier.New(notifier.Options{Sinks: []notifier.SinkConfig{c}}); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+	if err := (notifier.Options{RateLimit: notifier.RateLimitOptions{Key: "asn"}}).Validate(); err == nil {
+		t.Error("Expected an unknown rate limit key to be rejected")
+	}
+}
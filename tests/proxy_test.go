@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/proxy"
+)
+
+func setTrusted(t *testing.T, cidrs ...string) {
+	t.Helper()
+	prefixes, err := proxy.ParseTrusted(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetTrusted(prefixes)
+	t.Cleanup(func() { proxy.SetTrusted(nil) })
+}
+
+func TestProxy_ClientIP(t *testing.T) {
+	setTrusted(t, "10.0.0.0/8", "192.168.1.1")
+
+	tests := []struct {
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		// Untrusted peers can't claim another address
+		{"203.0.113.9", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"10.0.0.2", map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.2", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.3"}, "1.2.3.4"},
+		{"192.168.1.1", map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"10.0.0.2", map[string]string{"X-Forwarded-For": "garbage"}, "10.0.0.2"},
+		{"10.0.0.2", nil, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		got := proxy.ClientIP(netip.MustParseAddr(tt.peer), func(name string) string { return tt.headers[name] })
+		if got.String() != tt.want {
+			t.Errorf("peer %s, headers %v: expected %s, got %s", tt.peer, tt.headers, tt.want, got)
+		}
+	}
+
+	if _, err := proxy.ParseTrusted([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid CIDR to be rejected")
+	}
+}
+
+func TestProxy_Protocol(t *testing.T) {
+	v2 := func(ip [4]byte, port uint16) []byte {
+		b := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c")
+		b = append(b, ip[:]...)
+		b = append(b, 127, 0, 0, 1)
+		b = binary.BigEndian.AppendUint16(b, port)
+		return binary.BigEndian.AppendUint16(b, 80)
+	}
+
+	local := []string{"127.0.0.0/8"}
+	tests := []struct {
+		name    string
+		trusted []string
+		header  []byte
+		want    string
+	}{
+		{"v1", local, []byte("PROXY TCP4 198.51.100.7 127.0.0.1 40000 80\r\n"), "198.51.100.7:40000"},
+		{"v1 IPv6", local, []byte("PROXY TCP6 2001:db8::7 ::1 40000 80\r\n"), "[2001:db8::7]:40000"},
+		{"v2", local, v2([4]byte{198, 51, 100, 8}, 40001), "198.51.100.8:40001"},
+		{"single address", []string{"127.0.0.1"}, []byte("PROXY TCP4 198.51.100.9 127.0.0.1 40002 80\r\n"), "198.51.100.9:40002"},
+		{"no header", local, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTrusted(t, tt.trusted...)
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pln := proxy.Listener(ln)
+			defer pln.Close()
+
+			client, err := net.Dial("tcp4", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write(append(tt.header, "HELLO WORLD!"...))
+
+			conn, err := pln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			want := tt.want
+			if want == "" {
+				want = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("expected remote address %s, got %s", want, got)
+			}
+			data := make([]byte, 12)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, data); err != nil || string(data) != "HELLO WORLD!" {
+				t.Errorf("expected the data after the header, got %q (%v)", data, err)
+			}
+		})
+	}
+
+	// Headers from untrusted peers are not parsed, and without a trusted
+	// list nobody is trusted
+	for _, trusted := range [][]string{{"10.0.0.0/8"}, nil} {
+		setTrusted(t, trusted...)
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pln := proxy.Listener(ln)
+		defer pln.Close()
+		client, err := net.Dial("tcp4", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.Write([]byte("PROXY TCP4 198.51.100.7 127.0.0.1 40000 80\r\n"))
+		conn, err := pln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+			t.Errorf("trusted %v: an untrusted peer must keep its address, got %s", trusted, got)
+		}
+	}
+}
+
+// TestProxy_ProtocolSilentClient checks that a trusted peer that waits for
+// the server to speak first is accepted without a header.
+func TestProxy_ProtocolSilentClient(t *testing.T) {
+	setTrusted(t, "127.0.0.0/8")
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := proxy.Listener(ln)
+	defer pln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := pln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(3 * time.Second):
+		t.Fatal("a silent client was never accepted")
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Errorf("expected the client's own address, got %s", got)
+	}
+
+	// The connection works normally once the server has spoken
+	conn.Write([]byte("220 ready\r\n"))
+	client.Write([]byte("HELO"))
+	data := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, data); err != nil || string(data) != "HELO" {
+		t.Errorf("expected the client's data, got %q (%v)", data, err)
+	}
+}
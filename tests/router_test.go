@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/config"
+	"github.com/Kartikey2011yadav/voidsink/internal/heffalump"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	routertrap "github.com/Kartikey2011yadav/voidsink/internal/traps/router"
+	_ "github.com/Kartikey2011yadav/voidsink/internal/traps/spider"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouter(t *testing.T) {
+	addr := "127.0.0.1:54327"
+	reply := func(name string) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) { ctx.WriteString(name) }
+	}
+	routes := []routertrap.Route{
+		{Path: "/wp-login.php", Handler: reply("login")},
+		{Path: "/api/*", Methods: []string{"GET"}, Handler: reply("api")},
+		{Path: "/*.php", Handler: reply("php")},
+		{Host: "*.example.com", Handler: reply("example")},
+		{Path: "/docs/*", Handler: reply("docs")},
+	}
+	opts := trap.DefaultHTTPOptions()
+	opts.ServerName = "nginx"
+	r, err := routertrap.New(addr, opts, routes, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Start(ctx)
+	defer r.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		method, host, path string
+		want               string
+	}{
+		{"GET", "", "/wp-login.php", "login"},
+		{"GET", "", "/api", "api"},
+		{"GET", "", "/api/v1/users", "api"},
+		{"POST", "", "/api/v1/users", "404"},
+		{"GET", "", "/apiary", "404"},
+		{"GET", "", "/xmlrpc.php", "php"},
+		{"GET", "WWW.Example.com:8085", "/anything", "example"},
+		{"GET", "", "/docs/index.html", "docs"},
+		{"GET", "", "/", "404"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+tt.path, nil)
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		got := string(body)
+		if resp.StatusCode == http.StatusNotFound {
+			got = "404"
+		}
+		if got != tt.want {
+			t.Errorf("%s %s%s: expected %q, got %q", tt.method, tt.host, tt.path, tt.want, got)
+		}
+		if server := resp.Header.Get("Server"); server != "nginx" {
+			t.Errorf("expected Server header %q, got %q", "nginx", server)
+		}
+	}
+
+	// Routes can be swapped while running
+	if err := r.Reconfigure(opts, []routertrap.Route{{Handler: reply("all")}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "all" {
+		t.Errorf("expected the new catch-all route, got %q", body)
+	}
+
+	if err := r.Reconfigure(opts, []routertrap.Route{{Path: "no-slash", Handler: reply("x")}}); err == nil {
+		t.Error("expected a path without a leading slash to be rejected")
+	}
+}
+
+// TestRouter_MountsTraps builds a router from its config and checks that
+// a route is served by the handler of the mounted trap.
+func TestRouter_MountsTraps(t *testing.T) {
+	h, err := heffalump.New(writeCorpus(t, "word1 word2 word3 word4 word5 word6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := trap.Deps{
+		Corpus: func(name string) (*heffalump.Heffalump, error) { return h, nil },
+	}
+	addr := "127.0.0.1:54328"
+
+	spec, err := trap.NewSpec(config.TrapConfig{
+		Name: "site",
+		Type: "http_router",
+		Addr: addr,
+		Options: map[string]interface{}{
+			"server_name": "Apache",
+			"routes": []interface{}{
+				map[string]interface{}{"path": "/wiki/*", "trap": "spider_trap"},
+			},
+		},
+	}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := spec.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.Start(ctx)
+	defer tr.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/wiki/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<a href=") {
+		t.Errorf("expected a spider_trap page, got %q", body)
+	}
+	if got := resp.Header.Get("Server"); got != "Apache" {
+		t.Errorf("expected the router's server name on mounted traps, got %q", got)
+	}
+
+	spec, err = trap.NewSpec(config.TrapConfig{
+		Name: "site",
+		Type: "http_router",
+		Options: map[string]interface{}{
+			"routes": []interface{}{map[string]interface{}{"trap": "ssh_tarpit"}},
+		},
+	}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spec.New(); err == nil || !strings.Contains(err.Error(), "not an HTTP trap") {
+		t.Errorf("expected non-HTTP traps to be rejected as routes, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/session"
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/valyala/fasthttp"
+)
+
+// TestSession checks what is recorded for connections that end in each way.
+func TestSession(t *testing.T) {
+	addr := "127.0.0.1:54331"
+	records := make(chan session.Record, 10)
+	session.OnEnd(func(r session.Record) {
+		if r.Trap == "session_test" {
+			select {
+			case records <- r:
+			default:
+			}
+		}
+	})
+
+	opts := trap.DefaultHTTPOptions()
+	opts.IdleTimeout = 500 * time.Millisecond
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type: "session_test",
+		Name: "Session Test Trap",
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) != "/stream" {
+				ctx.WriteString("hello")
+				return
+			}
+			sess := trap.SessionOf(ctx)
+			trap.StreamBody(ctx, func(w *bufio.Writer) {
+				chunk := strings.Repeat("a", 1024)
+				for {
+					n, err := w.WriteString(chunk)
+					if err != nil || w.Flush() != nil {
+						return
+					}
+					sess.AddUncompressed(n)
+				}
+			})
+		},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	next := func() session.Record {
+		t.Helper()
+		select {
+		case r := <-records:
+			return r
+		case <-time.After(2 * time.Second):
+			t.Fatal("No session ended")
+			return session.Record{}
+		}
+	}
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// Two requests, then the server closes
+	conn := dial()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	io.ReadAll(conn)
+	conn.Close()
+	r := next()
+	if r.Requests != 2 || r.EndReason != session.EndServerClose || r.BytesSent == 0 {
+		t.Errorf("Expected 2 requests, bytes sent and %s, got %+v", session.EndServerClose, r)
+	}
+
+	// The client hangs up in the middle of a stream
+	conn = dial()
+	conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: x\r\n\r\n"))
+	io.ReadFull(conn, make([]byte, 64*1024))
+	conn.Close()
+	r = next()
+	if r.EndReason != session.EndClientClose || r.BytesSent < 64*1024 || r.BytesUncompressed == 0 {
+		t.Errorf("Expected a streamed %s session, got %+v", session.EndClientClose, r)
+	}
+
+	// The client never sends the next request
+	conn = dial()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	r = next()
+	conn.Close()
+	if r.EndReason != session.EndTimeout || r.Duration < 500*time.Millisecond {
+		t.Errorf("Expected an idle %s session, got %+v", session.EndTimeout, r)
+	}
+
+	// Still connected when the trap shuts down
+	conn = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	if n := s.Active(); n != 1 {
+		t.Errorf("Expected 1 active session, got %d", n)
+	}
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	r = next()
+	if r.EndReason != session.EndShutdown {
+		t.Errorf("Expected a %s session, got %+v", session.EndShutdown, r)
+	}
+	if n := s.Active(); n != 0 {
+		t.Errorf("Expected no active session after shutdown, got %d", n)
+	}
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	smtptrap "github.com/Kartikey2011yadav/voidsink/internal/traps/smtp"
+)
+
+func TestSMTPTarpit_Relay(t *testing.T) {
+	// 1. Create Trap with a short greeting so the client gets through
+	captureDir := t.TempDir()
+	addr := "127.0.0.1:54323"
+	tr := smtptrap.New(addr, smtptrap.Options{
+		Hostname:      "mail.example.com",
+		Delay:         10 * time.Millisecond,
+		GreetingLines: 3,
+		CaptureDir:    captureDir,
+	}, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- tr.Start(ctx)
+	}()
+
+	// Give it a moment to start
+	time.Sleep(100 * time.Millisecond)
+
+	// 2. Relay a message like a spam bot would
+	msg := "Subject: Cheap pills\r\n\r\nBuy now!\r\n"
+	if err := smtp.SendMail(addr, nil, "spammer@example.org", []string{"victim@example.net"}, []byte(msg)); err != nil {
+		t.Fatalf("Failed to relay message: %v", err)
+	}
+
+	// 3. Verify the payload was captured to disk
+	files, err := filepath.Glob(filepath.Join(captureDir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 captured message, got %d", len(files))
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "X-VoidSink-Mail-From: spammer@example.org") {
+		t.Errorf("Captured message is missing envelope sender: %q", content)
+	}
+	if !strings.Contains(content, "X-VoidSink-Rcpt-To: victim@example.net") {
+		t.Errorf("Captured message is missing envelope recipient: %q", content)
+	}
+	if !strings.Contains(content, "Buy now!") {
+		t.Errorf("Captured message is missing body: %q", content)
+	}
+
+	// 4. Past the capture limit, messages are still accepted but not stored
+	tr.Reconfigure(smtptrap.Options{
+		Hostname:      "mail.example.com",
+		Delay:         10 * time.Millisecond,
+		GreetingLines: 3,
+		CaptureDir:    captureDir,
+		MaxCaptures:   2,
+	})
+	for i := 0; i < 3; i++ {
+		if err := smtp.SendMail(addr, nil, "spammer@example.org", []string{"victim@example.net"}, []byte(msg)); err != nil {
+			t.Fatalf("Failed to relay message past the capture limit: %v", err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(captureDir, "*.eml")); len(files) != 2 {
+		t.Errorf("Expected captures to stop at 2 files, got %d", len(files))
+	}
+
+	// 5. Shutdown
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Trap shutdown error: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Trap did not shut down in time")
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	sshtrap "github.com/Kartikey2011yadav/voidsink/internal/traps/ssh"
+)
+
+func TestSSHTarpit(t *testing.T) {
+	// 1. Create Trap with a short delay so the test doesn't wait long
+	addr := "127.0.0.1:54322"
+	tr := sshtrap.New(addr, trap.ListenOptions{}, 10*time.Millisecond, 16, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- tr.Start(ctx)
+	}()
+
+	// Give it a moment to start
+	time.Sleep(100 * time.Millisecond)
+
+	// 2. Connect like an SSH client would
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to trap: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// 3. Verify we receive pre-banner lines and never the version string
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 5; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read line %d: %v", i, err)
+		}
+		if !strings.HasSuffix(line, "\r\n") {
+			t.Errorf("Line %d is not CRLF terminated: %q", i, line)
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			t.Errorf("Line %d looks like a version banner: %q", i, line)
+		}
+		if len(line) > 18 {
+			t.Errorf("Line %d exceeds max length: %q", i, line)
+		}
+	}
+
+	// 4. Shutdown
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Trap shutdown error: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Trap did not shut down in time")
+	}
+}
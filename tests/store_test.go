@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/store"
+)
+
+func openStore(t *testing.T, opts store.Options) *store.Store {
+	t.Helper()
+	s, err := store.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestStore checks that events survive a restart and can be queried by
+// their indexes.
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "events.db")
+	now := time.Now()
+
+	s := openStore(t, store.Options{Path: path})
+	s.Record(store.Event{Time: now.Add(-3 * time.Minute), Kind: store.KindHit, Trap: "http_infinite", IP: "192.0.2.1", Path: "/a"})
+	s.Record(store.Event{Time: now.Add(-2 * time.Minute), Kind: store.KindHit, Trap: "login_trap", IP: "192.0.2.1", Path: "/b"})
+	s.Record(store.Event{Time: now.Add(-1 * time.Minute), Kind: store.KindCredential, Trap: "login_trap", IP: "192.0.2.2", Username: "admin", Password: "hunter2"})
+	s.Record(store.Event{Time: now, Kind: store.KindHit, Trap: "ssh_tarpit", IP: "192.0.2.1"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, store.Options{Path: path})
+	defer s.Close()
+
+	if n, err := s.Count(); err != nil || n != 4 {
+		t.Fatalf("Expected 4 events, got %d (%v)", n, err)
+	}
+
+	tests := []struct {
+		name  string
+		query store.Query
+		want  []string // Paths or traps, newest first
+	}{
+		{"all", store.Query{}, []string{"ssh_tarpit", "login_trap", "login_trap", "http_infinite"}},
+		{"ip", store.Query{IP: "192.0.2.1"}, []string{"ssh_tarpit", "login_trap", "http_infinite"}},
+		{"trap", store.Query{Trap: "login_trap"}, []string{"login_trap", "login_trap"}},
+		{"ip and trap", store.Query{IP: "192.0.2.1", Trap: "login_trap"}, []string{"login_trap"}},
+		{"kind", store.Query{Kind: store.KindCredential}, []string{"login_trap"}},
+		{"since", store.Query{Since: now.Add(-90 * time.Second)}, []string{"ssh_tarpit", "login_trap"}},
+		{"until", store.Query{IP: "192.0.2.1", Until: now.Add(-time.Minute)}, []string{"login_trap", "http_infinite"}},
+		{"limit", store.Query{Limit: 1}, []string{"ssh_tarpit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, e.Trap)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	events, _ := s.Query(store.Query{Kind: store.KindCredential})
+	if len(events) != 1 || events[0].Username != "admin" || events[0].Password != "hunter2" || events[0].ID == 0 {
+		t.Errorf("Expected the stored credential, got %+v", events)
+	}
+
+	// A nil store ignores everything
+	var none *store.Store
+	none.Record(store.Event{Kind: store.KindHit})
+	if events, err := none.Query(store.Query{}); events != nil || err != nil {
+		t.Errorf("Expected nothing from a nil store, got %v, %v", events, err)
+	}
+}
+
+// TestStore_Retention checks that old and excess events are deleted.
+func TestStore_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	now := time.Now()
+
+	s := openStore(t, store.Options{Path: path})
+	for i, age := range []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		s.Record(store.Event{Time: now.Add(-age), Kind: store.KindHit, Trap: "http_infinite", IP: "192.0.2.1", Path: string(rune('a' + i))})
+	}
+	s.Close()
+
+	// Retention is applied when the store opens
+	s = openStore(t, store.Options{Path: path, Retention: 24 * time.Hour, MaxEvents: 2})
+	events, err := s.Query(store.Query{IP: "192.0.2.1"})
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Path != "d" || events[1].Path != "c" {
+		t.Errorf("Expected the 2 newest events, got %+v", events)
+	}
+}
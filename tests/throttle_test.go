@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/throttle"
+)
+
+// countingWriter records how many writes and flushes it received.
+type countingWriter struct {
+	bytes.Buffer
+	writes  int
+	flushes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func (c *countingWriter) Flush() error {
+	c.flushes++
+	return nil
+}
+
+func TestThrottle_NilLimiterPassesThrough(t *testing.T) {
+	l := throttle.NewLimiter(throttle.Options{}, nil)
+	if l != nil {
+		t.Fatal("Expected nil limiter when no limits are configured")
+	}
+
+	dst := &countingWriter{}
+	if w := l.Writer(dst, nil); w != dst {
+		t.Error("Nil limiter should return the underlying writer unchanged")
+	}
+}
+
+func TestThrottle_RateAndChunks(t *testing.T) {
+	// 1. 1000 B/s in 10 byte chunks
+	l := throttle.NewLimiter(throttle.Options{BytesPerSecond: 1000, ChunkSize: 10}, nil)
+	dst := &countingWriter{}
+	w := l.Writer(dst, nil)
+
+	// 2. Write 100 bytes; the first chunk is free, the other 90 bytes cost ~90ms
+	start := time.Now()
+	n, err := w.Write(make([]byte, 100))
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("Expected 100 bytes written, got %d", n)
+	}
+
+	// 3. Verify pacing and chunking
+	if elapsed < 80*time.Millisecond {
+		t.Errorf("Write finished too fast for 1000 B/s: %v", elapsed)
+	}
+	if dst.writes != 10 {
+		t.Errorf("Expected 10 chunked writes, got %d", dst.writes)
+	}
+	if dst.flushes != 10 {
+		t.Errorf("Expected a flush after every chunk, got %d", dst.flushes)
+	}
+}
+
+func TestThrottle_GlobalBudget(t *testing.T) {
+	// 1. Two unlimited streams sharing a 1000 B/s budget
+	budget := throttle.NewBudget(1000)
+	l := throttle.NewLimiter(throttle.Options{ChunkSize: 100}, budget)
+	w1 := l.Writer(&countingWriter{}, nil)
+	w2 := l.Writer(&countingWriter{}, nil)
+
+	// 2. The initial burst covers the first 1000 bytes, the next 200 cost ~200ms
+	start := time.Now()
+	w1.Write(make([]byte, 600))
+	w2.Write(make([]byte, 600))
+	elapsed := time.Since(start)
+
+	if elapsed < 180*time.Millisecond {
+		t.Errorf("Global budget was not enforced across writers: %v", elapsed)
+	}
+}
+
+func TestThrottle_SlowRateDrips(t *testing.T) {
+	// 1. 10 B/s without a chunk size drips 10 bytes at a time
+	l := throttle.NewLimiter(throttle.Options{BytesPerSecond: 10}, nil)
+	dst := &countingWriter{}
+	w := l.Writer(dst, nil)
+
+	// 2. The first chunk goes out at once, the second after about a second
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected 20 bytes to take about a second at 10 B/s, took %v", elapsed)
+	}
+	if dst.writes != 2 {
+		t.Errorf("Expected 2 chunks of 10 bytes, got %d writes", dst.writes)
+	}
+}
+
+func TestThrottle_DoneInterruptsWait(t *testing.T) {
+	// 1. A 1 B/s stream that would wait about a minute
+	l := throttle.NewLimiter(throttle.Options{BytesPerSecond: 1, ChunkSize: 60}, nil)
+	done := make(chan struct{})
+	w := l.Writer(&countingWriter{}, done)
+	if _, err := w.Write(make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. Closing done ends the next wait right away
+	time.AfterFunc(50*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 60)); err != throttle.ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the wait to be cut short, took %v", elapsed)
+	}
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kartikey2011yadav/voidsink/internal/trap"
+	"github.com/valyala/fasthttp"
+)
+
+// TestHTTPServer_TLS serves HTTPS with generated certificates and picks the
+// persona by SNI.
+func TestHTTPServer_TLS(t *testing.T) {
+	addr := "127.0.0.1:54329"
+	opts := trap.DefaultHTTPOptions()
+	opts.ServerName = "nginx"
+	opts.TLS = trap.TLSOptions{
+		Enabled: true,
+		Certificate: trap.Certificate{
+			Organization: "Acme Corp",
+			SANs:         []string{"www.acme.test", "127.0.0.1"},
+		},
+		Personas: []trap.TLSPersona{{
+			Hosts:      []string{"mail.example.test", "*.owa.example.test"},
+			ServerName: "Microsoft-IIS/10.0",
+		}},
+	}
+
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type:    "test_trap",
+		Name:    "Test Trap",
+		Handler: func(ctx *fasthttp.RequestCtx) { ctx.WriteString("hello") },
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	defer s.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(sni string) (*http.Response, string) {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: sni, InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	resp, body := get("www.acme.test")
+	if body != "hello" {
+		t.Errorf("expected the handler to run over TLS, got %q", body)
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	if cert.Subject.CommonName != "www.acme.test" || len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != "Acme Corp" {
+		t.Errorf("unexpected subject %v", cert.Subject)
+	}
+	if len(cert.IPAddresses) != 1 || cert.VerifyHostname("127.0.0.1") != nil {
+		t.Errorf("expected 127.0.0.1 as IP SAN, got %v", cert.IPAddresses)
+	}
+	if cert.NotBefore.After(time.Now()) || cert.NotBefore.Minute() != 0 || cert.NotBefore.Second() != 0 {
+		t.Errorf("certificate should not look freshly issued: %v", cert.NotBefore)
+	}
+	if got := resp.Header.Get("Server"); got != "nginx" {
+		t.Errorf("expected Server header %q, got %q", "nginx", got)
+	}
+
+	resp, _ = get("autodiscover.owa.example.test")
+	if err := resp.TLS.PeerCertificates[0].VerifyHostname("autodiscover.owa.example.test"); err != nil {
+		t.Errorf("expected the persona certificate: %v", err)
+	}
+	if got := resp.Header.Get("Server"); got != "Microsoft-IIS/10.0" {
+		t.Errorf("expected the persona Server header, got %q", got)
+	}
+
+	opts.TLS.Personas = nil
+	opts.TLS.CertFile = "missing.pem"
+	if _, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{}, nil, nil); err == nil {
+		t.Error("expected cert_file without key_file to be rejected")
+	}
+}
+
+// TestHTTPServer_TLSFingerprint checks that handlers see the JA3/JA4
+// fingerprint of the connection and the JA4H of the request.
+func TestHTTPServer_TLSFingerprint(t *testing.T) {
+	addr := "127.0.0.1:54330"
+	opts := trap.DefaultHTTPOptions()
+	opts.TLS = trap.TLSOptions{Enabled: true, Certificate: trap.Certificate{SANs: []string{"www.acme.test"}}}
+
+	s, err := trap.NewHTTPServer(addr, opts, trap.HTTPInfo{
+		Type: "test_trap",
+		Name: "Test Trap",
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			client := trap.ClientOf(ctx)
+			if client.TLS != nil {
+				ctx.WriteString(client.TLS.JA3 + " " + client.TLS.JA4 + " " + client.HTTP.JA4H)
+			}
+		},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	defer s.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "www.acme.test", InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	fields := strings.Fields(string(body))
+	if len(fields) != 3 || len(fields[0]) != 32 || !strings.HasPrefix(fields[1], "t13d") {
+		t.Fatalf("expected the TLS fingerprint of the Go client, got %q", body)
+	}
+	// Host, User-Agent and Accept-Encoding
+	if !strings.HasPrefix(fields[2], "ge11nn030000_") {
+		t.Errorf("expected the JA4H of the Go client, got %q", fields[2])
+	}
+}